@@ -0,0 +1,155 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"os"
+	"path"
+	"sort"
+)
+
+// HostBinDir holds the host commands. The exported bin directory is the last
+// PATH entry so host commands shadow exported applications of the same name.
+const HostBinDir = "/usr/bin"
+
+// Why the first provider of a contested command was chosen.
+const (
+	ReasonSelected  = "selected"
+	ReasonPriority  = "priority"
+	ReasonInstalled = "installed first"
+)
+
+// BundleExports is what an installed bundle exports. Docs tells which of its
+// man pages and shell completions are exported.
+type BundleExports struct {
+	Bundle  Bundle
+	Apps    []AppConfig
+	Matches []BinMatch
+	Docs    string
+}
+
+// Provider is a bundle exporting a command.
+type Provider struct {
+	ID     string `json:"id" toml:"id"`
+	Name   string `json:"name" toml:"name"`
+	Target string `json:"target" toml:"target"`
+}
+
+// Collision is a command exported by more than one bundle or shadowed by a
+// host command. Providers are in order of preference, only the first one
+// is exported and Reason tells why when there are several.
+type Collision struct {
+	Command   string     `json:"command" toml:"command"`
+	Providers []Provider `json:"providers" toml:"provider"`
+	Reason    string     `json:"reason" toml:"reason"`
+	Host      string     `json:"host" toml:"host"`
+}
+
+// Contested reports whether more than one bundle exports the command.
+func (c Collision) Contested() bool {
+	return len(c.Providers) > 1
+}
+
+// ResolveExports lists what every bundle installed in contentdir exports and
+// the collisions between the exported commands. A command exported by several
+// bundles is only kept for the bundle chosen for it with the alternatives in
+// the state database, else for the first bundle in the settings priority
+// list, else for the bundle installed first.
+func ResolveExports(statedir string, contentdir string, settings Settings) ([]BundleExports, []Collision, error) {
+	bundles, err := GetBundles(contentdir)
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := LoadState(statedir)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.SliceStable(bundles, func(i, j int) bool {
+		if ri, rj := settings.rank(bundles[i]), settings.rank(bundles[j]); ri != rj {
+			return ri < rj
+		}
+		ti := state.Track(bundles[i], statedir, contentdir).Installed
+		tj := state.Track(bundles[j], statedir, contentdir).Installed
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return bundles[i].ID < bundles[j].ID
+	})
+
+	exports := []BundleExports{}
+	byID := make(map[string]Bundle)
+	providers := make(map[string][]Provider)
+	var commands []string
+	for _, b := range bundles {
+		apps, matches := ResolveApps(b.ChrootDir(contentdir), b.Config.Bundle)
+		exports = append(exports, BundleExports{Bundle: b, Apps: apps, Matches: matches, Docs: settings.DocsFor(b)})
+		byID[b.ID] = b
+		for _, app := range apps {
+			if _, ok := providers[app.Name]; !ok {
+				commands = append(commands, app.Name)
+			}
+			providers[app.Name] = append(providers[app.Name], Provider{ID: b.ID, Name: b.Config.Bundle.Name, Target: app.Target})
+		}
+	}
+	sort.Strings(commands)
+
+	collisions := []Collision{}
+	chosen := make(map[string]string)
+	for _, command := range commands {
+		c := Collision{Command: command, Providers: providers[command]}
+		if host := path.Join(HostBinDir, command); pathExists(host) {
+			c.Host = host
+		}
+		if c.Contested() {
+			c.Reason = ReasonInstalled
+			if settings.rank(byID[c.Providers[0].ID]) < len(settings.Priority) {
+				c.Reason = ReasonPriority
+			}
+			for i, p := range c.Providers {
+				if p.ID != state.Alternatives[command] {
+					continue
+				}
+				reordered := []Provider{p}
+				for j, other := range c.Providers {
+					if j != i {
+						reordered = append(reordered, other)
+					}
+				}
+				c.Providers = reordered
+				c.Reason = ReasonSelected
+				break
+			}
+		}
+		chosen[command] = c.Providers[0].ID
+		if c.Contested() || c.Host != "" {
+			collisions = append(collisions, c)
+		}
+	}
+	for i, e := range exports {
+		apps := []AppConfig{}
+		for _, app := range e.Apps {
+			if chosen[app.Name] == e.Bundle.ID {
+				apps = append(apps, app)
+			}
+		}
+		exports[i].Apps = apps
+	}
+	return exports, collisions, nil
+}
+
+func pathExists(p string) bool {
+	_, err := os.Lstat(p)
+	return err == nil
+}
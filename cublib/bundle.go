@@ -0,0 +1,143 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Bundle is an installed 3rd-party bundle, identified by the encoded name used
+// for its chroot and state directories.
+type Bundle struct {
+	ID     string
+	Config TomlConfig
+}
+
+// ChrootDir returns the directory the bundle content is installed to.
+func (b Bundle) ChrootDir(contentdir string) string {
+	return path.Join(contentdir, "chroot", b.ID)
+}
+
+// ConfigPath returns the path of the config saved when the bundle was added.
+func (b Bundle) ConfigPath(contentdir string) string {
+	return path.Join(contentdir, "chroot", b.ID) + ".toml"
+}
+
+// StateDir returns the swupd state directory used for the bundle.
+func (b Bundle) StateDir(statedir string) string {
+	return path.Join(statedir, "3rd-party", b.ID)
+}
+
+// GetBundles returns every bundle installed under contentdir. Bundles whose
+// config can't be read are skipped with a warning.
+func GetBundles(contentdir string) ([]Bundle, error) {
+	chrootdir := path.Join(contentdir, "chroot")
+	dlist, err := ioutil.ReadDir(chrootdir)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read 3rd-party content directory (%s): %s", chrootdir, err)
+	}
+
+	var bundles []Bundle
+	for _, p := range dlist {
+		// chroot dir should only be chroot directories and conf files so skip the conf files
+		// as it is easier to make those names from the directory names
+		if ext := filepath.Ext(p.Name()); ext != "" {
+			continue
+		}
+		confPath := "file://" + path.Join(chrootdir, p.Name()) + ".toml"
+		conf, err := GetConfig(confPath)
+		if err != nil {
+			log.Printf("WARNING: Unable to read 3rd-party config (%s): %s", confPath, err)
+			continue
+		}
+		bundles = append(bundles, Bundle{ID: p.Name(), Config: conf})
+	}
+	return bundles, nil
+}
+
+// FindBundle looks up an installed bundle by its ID or by its name. Names are
+// only unique per URL so an ambiguous name is an error.
+func FindBundle(contentdir string, name string) (Bundle, error) {
+	bundles, err := GetBundles(contentdir)
+	if err != nil {
+		return Bundle{}, err
+	}
+	var matches []Bundle
+	for _, b := range bundles {
+		if b.ID == name {
+			return b, nil
+		}
+		if b.Config.Bundle.Name == name {
+			matches = append(matches, b)
+		}
+	}
+	if len(matches) == 0 {
+		return Bundle{}, fmt.Errorf("Bundle %s is not installed", name)
+	}
+	if len(matches) > 1 {
+		var ids []string
+		for _, b := range matches {
+			ids = append(ids, b.ID)
+		}
+		return Bundle{}, fmt.Errorf("Bundle name %s is ambiguous, use one of the IDs: %s", name, strings.Join(ids, ", "))
+	}
+	return matches[0], nil
+}
+
+// GetInstalledVersion reads the version of content installed into a bundle chroot.
+func GetInstalledVersion(pchrootdir string) (string, error) {
+	releasePath := path.Join(pchrootdir, "usr", "lib", "os-release")
+	f, err := os.Open(releasePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "VERSION_ID=") {
+			return strings.Trim(strings.TrimPrefix(line, "VERSION_ID="), "\"'"), nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("No VERSION_ID in %s", releasePath)
+}
+
+// GetUpdatedTime returns when the installed version of the content in
+// pchrootdir was put in place, zero when it is unknown.
+func GetUpdatedTime(pchrootdir string) time.Time {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path.Join(pchrootdir, "usr", "lib", "os-release"), &st); err != nil {
+		return time.Time{}
+	}
+	return time.Unix(st.Ctim.Unix())
+}
+
+// GetUpdatedConfig loads the config shipped in the installed content which can
+// differ from the config saved when the bundle was added.
+func GetUpdatedConfig(pchrootdir string) (TomlConfig, error) {
+	return GetConfig("file://" + path.Join(pchrootdir, "usr", "user-config.toml"))
+}
@@ -0,0 +1,83 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+)
+
+// CertInfo holds the identifying details of a content signing certificate.
+type CertInfo struct {
+	Subject     string
+	Fingerprint string
+	NotAfter    time.Time
+}
+
+// GetBundleCertPath returns where the signing certificate is shipped in a bundle chroot.
+func GetBundleCertPath(pchrootdir string) string {
+	return path.Join(pchrootdir, "usr", "share", "clear", "update-ca", "Swupd_Root.pem")
+}
+
+// ParseCert reads the first PEM certificate in buffer.
+func ParseCert(buffer []byte) (CertInfo, error) {
+	block, _ := pem.Decode(buffer)
+	if block == nil {
+		return CertInfo{}, fmt.Errorf("No PEM data found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return CertInfo{}, err
+	}
+	sum := sha256.Sum256(cert.Raw)
+	hexbytes := make([]string, len(sum))
+	for i, b := range sum {
+		hexbytes[i] = fmt.Sprintf("%02X", b)
+	}
+	return CertInfo{
+		Subject:     cert.Subject.String(),
+		Fingerprint: strings.Join(hexbytes, ":"),
+		NotAfter:    cert.NotAfter,
+	}, nil
+}
+
+// GetCertInfo reads the certificate at certPath.
+func GetCertInfo(certPath string) (CertInfo, error) {
+	buffer, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return CertInfo{}, err
+	}
+	return ParseCert(buffer)
+}
+
+// GetRemoteCertInfo reads the certificate published at uri.
+func GetRemoteCertInfo(uri string) (CertInfo, error) {
+	reader, err := openURI(uri)
+	if err != nil {
+		return CertInfo{}, err
+	}
+	defer reader.Close()
+	buffer, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return CertInfo{}, err
+	}
+	return ParseCert(buffer)
+}
@@ -16,11 +16,14 @@ package cublib
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"strings"
 	"github.com/BurntSushi/toml"
 )
 
@@ -34,6 +37,160 @@ type BundleConfig struct {
 	Includes    []string
 	URL         string
 	Bin         []string
+	App         []AppConfig
+	Units       []string
+}
+
+// AppConfig is an application exported from the bundle as the command Name,
+// which defaults to the basename of Target. Target and WorkDir are paths
+// inside the bundle content, Args are passed to Target ahead of the arguments
+// the command is run with and Env is set in its environment.
+type AppConfig struct {
+	Name        string
+	Target      string
+	Args        []string
+	Env         map[string]string
+	WorkDir     string
+	Description string
+}
+
+// String describes the application by its target, naming the command when it
+// is exported under a different name.
+func (a AppConfig) String() string {
+	if a.Name == path.Base(a.Target) {
+		return a.Target
+	}
+	return fmt.Sprintf("%s (%s)", a.Target, a.Name)
+}
+
+// isBinPattern reports whether a Bin entry is a glob pattern or an exclude
+// rather than the path of an application.
+func isBinPattern(b string) bool {
+	return strings.HasPrefix(b, "!") || strings.ContainsAny(b, "*?[")
+}
+
+// Apps lists the applications named by the bundle config, the paths in Bin
+// followed by the App entries. Bin patterns are only expanded against the
+// installed content by ResolveApps.
+func (c BundleConfig) Apps() []AppConfig {
+	apps, _ := c.apps()
+	return apps
+}
+
+// apps lists the applications Apps does along with the problems with the
+// entries it leaves out. Invalid Bin and App entries are skipped and of the
+// entries exporting the same command the first one is used.
+func (c BundleConfig) apps() ([]AppConfig, []error) {
+	var problems []error
+	candidates := []AppConfig{}
+	for _, b := range c.Bin {
+		if isBinPattern(b) {
+			continue
+		}
+		if err := validateBin(b); err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		candidates = append(candidates, AppConfig{Name: path.Base(b), Target: b})
+	}
+	for _, app := range c.App {
+		if err := validateApp(app); err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		candidates = append(candidates, app)
+	}
+	apps := []AppConfig{}
+	exported := make(map[string]string)
+	for _, app := range candidates {
+		if target, ok := exported[app.Name]; ok {
+			problems = append(problems, fmt.Errorf("command %s is exported more than once, using %s", app.Name, target))
+			continue
+		}
+		exported[app.Name] = app.Target
+		apps = append(apps, app)
+	}
+	return apps, problems
+}
+
+// Validate returns the problems with what the bundle config exports. Installed
+// bundles keep exporting the entries without problems, only add refuses such
+// a config.
+func (c BundleConfig) Validate() []error {
+	_, problems := c.apps()
+	_, patternProblems := c.patterns()
+	problems = append(problems, patternProblems...)
+	for _, unit := range c.Units {
+		if err := validateUnit(unit); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return problems
+}
+
+// Patterns lists the glob patterns in Bin, excludes included.
+func (c BundleConfig) Patterns() []string {
+	patterns, _ := c.patterns()
+	return patterns
+}
+
+// patterns lists the patterns Patterns does along with the problems with the
+// invalid ones it leaves out.
+func (c BundleConfig) patterns() ([]string, []error) {
+	var problems []error
+	patterns := []string{}
+	for _, b := range c.Bin {
+		if !isBinPattern(b) {
+			continue
+		}
+		if err := validateBin(b); err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		patterns = append(patterns, b)
+	}
+	return patterns, problems
+}
+
+// isContentPath reports whether p is a clean absolute path, so it can't
+// escape the bundle content it is resolved in.
+func isContentPath(p string) bool {
+	return path.IsAbs(p) && path.Clean(p) == p
+}
+
+// validateBin checks a Bin entry is a clean absolute path or pattern, which
+// an exclude starts with "!" in front of.
+func validateBin(b string) error {
+	p := strings.TrimPrefix(b, "!")
+	if !isContentPath(p) {
+		return fmt.Errorf("invalid bin entry %s: not a clean absolute path", b)
+	}
+	if _, err := path.Match(p, ""); err != nil {
+		return fmt.Errorf("invalid bin pattern %s: %s", b, err)
+	}
+	return nil
+}
+
+// validateApp checks an App entry, ReadConfig has defaulted its Name.
+func validateApp(app AppConfig) error {
+	if app.Target == "" {
+		return fmt.Errorf("app %s has no target", app.Name)
+	}
+	if !isContentPath(app.Target) {
+		return fmt.Errorf("app %s target %s is not a clean absolute path", app.Name, app.Target)
+	}
+	if app.WorkDir != "" && !isContentPath(app.WorkDir) {
+		return fmt.Errorf("app %s workdir %s is not a clean absolute path", app.Name, app.WorkDir)
+	}
+	if strings.Contains(app.Name, "/") || strings.HasPrefix(app.Name, ".") {
+		return fmt.Errorf("invalid app name %s", app.Name)
+	}
+	for k := range app.Env {
+		if k == "" || strings.Contains(k, "=") {
+			return fmt.Errorf("app %s has an invalid environment variable name %s", app.Name, k)
+		}
+	}
+	return nil
 }
 
 func ReadConfig(buffer io.Reader) (TomlConfig, error) {
@@ -42,6 +199,11 @@ func ReadConfig(buffer io.Reader) (TomlConfig, error) {
 	if _, err := toml.DecodeReader(buffer, &config); err != nil {
 		return TomlConfig{}, err
 	}
+	for i, app := range config.Bundle.App {
+		if app.Name == "" {
+			config.Bundle.App[i].Name = path.Base(app.Target)
+		}
+	}
 
 	return config, nil
 }
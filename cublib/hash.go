@@ -0,0 +1,83 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// ZeroHash is the manifest hash used for deleted files.
+const ZeroHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// hashDirname is the data hashed in place of contents for directories.
+const hashDirname = "DIRECTORY"
+
+func hmacSha256(key []byte, data []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashKey builds the HMAC key swupd derives from the file metadata. It is the
+// hex HMAC of swupd's struct update_stat (mode, uid, gid, rdev and size as
+// 64 bit values) over the extended attributes blob, which is empty as
+// 3rd-party content carries no extended attributes.
+func hashKey(st *syscall.Stat_t, size int64) []byte {
+	stat := make([]byte, 40)
+	binary.LittleEndian.PutUint64(stat[0:], uint64(st.Mode))
+	binary.LittleEndian.PutUint64(stat[8:], uint64(st.Uid))
+	binary.LittleEndian.PutUint64(stat[16:], uint64(st.Gid))
+	binary.LittleEndian.PutUint64(stat[24:], uint64(st.Rdev))
+	binary.LittleEndian.PutUint64(stat[32:], uint64(size))
+	return []byte(hmacSha256(stat, nil))
+}
+
+// GetHash computes the swupd manifest hash of the file, directory or symlink
+// at fpath, the same value `swupd hashdump` reports for it.
+func GetHash(fpath string) (string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Lstat(fpath, &st); err != nil {
+		return "", &os.PathError{Op: "lstat", Path: fpath, Err: err}
+	}
+	switch st.Mode & syscall.S_IFMT {
+	case syscall.S_IFLNK:
+		target, err := os.Readlink(fpath)
+		if err != nil {
+			return "", err
+		}
+		return hmacSha256(hashKey(&st, st.Size), []byte(target)), nil
+	case syscall.S_IFDIR:
+		return hmacSha256(hashKey(&st, 0), []byte(hashDirname)), nil
+	case syscall.S_IFREG:
+		f, err := os.Open(fpath)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		mac := hmac.New(sha256.New, hashKey(&st, st.Size))
+		if _, err = io.Copy(mac, f); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(mac.Sum(nil)), nil
+	}
+	return "", fmt.Errorf("Unsupported file type for %s", fpath)
+}
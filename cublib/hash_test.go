@@ -0,0 +1,86 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"os"
+	"path"
+	"syscall"
+	"testing"
+)
+
+// swupdDirHash is what swupd records for a root owned directory with mode
+// 0755, as found in every Clear Linux manifest.
+const swupdDirHash = "6c27df6efcd6fc401ff1bc67c970b83eef115f6473db4fb9d57e5de317eba96e"
+
+// rootHashes are the hashes of root owned content.
+var rootHashes = []struct {
+	name string
+	mode uint32
+	data string
+	want string
+}{
+	{"dir", syscall.S_IFDIR | 0755, hashDirname, swupdDirHash},
+	{"file", syscall.S_IFREG | 0644, "hello\n", "f716c6cb18be9041e1748d52be544645cfc98d2c99dd7e72bdd6f702aa1ee744"},
+	{"exec", syscall.S_IFREG | 0755, "#!/bin/sh\necho hello\n", "5cc3ed3eec0be3f03806bd50713bccc4b1fececadea3bd980ecbe7c79cf3e031"},
+	{"empty", syscall.S_IFREG | 0644, "", "28cb7c6cad23920f344157f48ae353caca34e9a2f5cff934a4fdaaf7509f2062"},
+	{"link", syscall.S_IFLNK | 0777, "../lib/target", "3d1b83014513540012635e7fb29e8bd77715c7d90d6e578e868d564c1fe49152"},
+}
+
+func TestHashKey(t *testing.T) {
+	for _, tt := range rootHashes {
+		st := syscall.Stat_t{Mode: tt.mode}
+		size := int64(len(tt.data))
+		if tt.mode&syscall.S_IFMT == syscall.S_IFDIR {
+			size = 0
+		}
+		if got := hmacSha256(hashKey(&st, size), []byte(tt.data)); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetHash(t *testing.T) {
+	if os.Getuid() != 0 || os.Getgid() != 0 {
+		t.Skip("the expected hashes are the ones of root owned files")
+	}
+	dir := t.TempDir()
+	for _, tt := range rootHashes {
+		p := path.Join(dir, tt.name)
+		switch tt.mode & syscall.S_IFMT {
+		case syscall.S_IFDIR:
+			if err := os.Mkdir(p, 0755); err != nil || os.Chmod(p, 0755) != nil {
+				t.Fatal(err)
+			}
+		case syscall.S_IFLNK:
+			if err := os.Symlink(tt.data, p); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			mkfile(t, p, tt.data, os.FileMode(tt.mode&0777))
+		}
+		if got, err := GetHash(p); err != nil || got != tt.want {
+			t.Errorf("%s: got %s (%v), want %s", tt.name, got, err, tt.want)
+		}
+	}
+	if err := syscall.Mkfifo(path.Join(dir, "fifo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"fifo", "missing"} {
+		if got, err := GetHash(path.Join(dir, name)); err == nil {
+			t.Errorf("%s: expected an error, got %s", name, got)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Operations recorded in the history log.
+const (
+	OpAdd    = "add"
+	OpRemove = "remove"
+	OpUpdate = "update"
+	OpPost   = "post-process"
+)
+
+// HistoryEntry is one operation recorded in the history log.
+type HistoryEntry struct {
+	Time        time.Time `json:"time" toml:"time"`
+	UID         int       `json:"uid" toml:"uid"`
+	SudoUser    string    `json:"sudo_user" toml:"sudo_user"`
+	Command     string    `json:"command" toml:"command"`
+	Operation   string    `json:"operation" toml:"operation"`
+	ID          string    `json:"id" toml:"id"`
+	Name        string    `json:"name" toml:"name"`
+	URL         string    `json:"url" toml:"url"`
+	FromVersion string    `json:"from_version" toml:"from_version"`
+	ToVersion   string    `json:"to_version" toml:"to_version"`
+	Result      string    `json:"result" toml:"result"`
+	Error       string    `json:"error" toml:"error"`
+}
+
+func historyPath(statedir string) string {
+	return path.Join(statedir, "3rd-party", "history.log")
+}
+
+// NewHistoryEntry starts an entry for operation run by the current process.
+func NewHistoryEntry(operation string) HistoryEntry {
+	return HistoryEntry{
+		Time:      time.Now().UTC(),
+		UID:       os.Getuid(),
+		SudoUser:  os.Getenv("SUDO_USER"),
+		Command:   strings.Join(os.Args, " "),
+		Operation: operation,
+	}
+}
+
+// Finish sets the outcome of the entry from err.
+func (e *HistoryEntry) Finish(err error) {
+	if err != nil {
+		e.Result = ResultFailure
+		e.Error = err.Error()
+	} else {
+		e.Result = ResultSuccess
+		e.Error = ""
+	}
+}
+
+// AppendHistory adds e to the end of the history log. The log is only ever
+// appended to, one JSON object per line.
+func AppendHistory(statedir string, e HistoryEntry) error {
+	if err := os.MkdirAll(path.Dir(historyPath(statedir)), 0700); err != nil {
+		return err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(historyPath(statedir), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = out.Write(append(line, '\n')); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// ReadHistory loads every entry of the history log, oldest first. A missing
+// log is empty.
+func ReadHistory(statedir string) ([]HistoryEntry, error) {
+	entries := []HistoryEntry{}
+	in, err := os.Open(historyPath(statedir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	defer in.Close()
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var e HistoryEntry
+		if err = json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("Invalid history entry (%s:%d): %s", historyPath(statedir), n, err)
+		}
+		entries = append(entries, e)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
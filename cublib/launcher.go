@@ -0,0 +1,185 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Names of the launcher and its metadata in the exported bin directory. Every
+// exported application is a symlink to the launcher.
+const (
+	LauncherName     = ".launcher"
+	launcherMetadata = ".launcher.json"
+)
+
+// LauncherPath is where the launcher is installed on the host. When it is
+// missing the launcher next to the running executable is used instead.
+const LauncherPath = "/usr/libexec/3rd-party-launcher"
+
+// LauncherApp describes how the launcher runs an exported application.
+type LauncherApp struct {
+	ID            string            `json:"id"`
+	App           string            `json:"app"`
+	Target        string            `json:"target"`
+	Args          []string          `json:"args,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+	WorkDir       string            `json:"workdir,omitempty"`
+	Path          []string          `json:"path"`
+	LdLibraryPath []string          `json:"ld_library_path"`
+}
+
+// LauncherMetadata maps the exported command names to their applications.
+// Bundles has the fingerprint of every bundle the applications were exported
+// for, to tell which bundles changed since.
+type LauncherMetadata struct {
+	Apps    map[string]LauncherApp `json:"apps"`
+	Bundles map[string]string      `json:"bundles"`
+}
+
+// prependEnv returns environ with dirs put in front of the list variable
+// name, without leaving an empty entry when the variable is unset or empty.
+func prependEnv(environ []string, name string, dirs []string) []string {
+	if len(dirs) == 0 {
+		return environ
+	}
+	value := strings.Join(dirs, ":")
+	result := make([]string, 0, len(environ)+1)
+	for _, e := range environ {
+		if strings.HasPrefix(e, name+"=") {
+			if old := e[len(name)+1:]; old != "" {
+				value += ":" + old
+			}
+			continue
+		}
+		result = append(result, e)
+	}
+	return append(result, name+"="+value)
+}
+
+// setEnv returns environ with name set to value.
+func setEnv(environ []string, name string, value string) []string {
+	result := make([]string, 0, len(environ)+1)
+	for _, e := range environ {
+		if !strings.HasPrefix(e, name+"=") {
+			result = append(result, e)
+		}
+	}
+	return append(result, name+"="+value)
+}
+
+// Environ returns environ adjusted for running the application. The bundle
+// directories are added to the search paths after setting the application's
+// variables so they are found even when it sets PATH.
+func (a LauncherApp) Environ(environ []string) []string {
+	for name, value := range a.Env {
+		environ = setEnv(environ, name, value)
+	}
+	environ = prependEnv(environ, "PATH", a.Path)
+	return prependEnv(environ, "LD_LIBRARY_PATH", a.LdLibraryPath)
+}
+
+// ReadLauncherMetadata loads the launcher metadata from the bin directory.
+func ReadLauncherMetadata(bindir string) (LauncherMetadata, error) {
+	var meta LauncherMetadata
+	content, err := ioutil.ReadFile(path.Join(bindir, launcherMetadata))
+	if err != nil {
+		return meta, err
+	}
+	if err = json.Unmarshal(content, &meta); err != nil {
+		return meta, fmt.Errorf("Invalid launcher metadata (%s): %s", path.Join(bindir, launcherMetadata), err)
+	}
+	return meta, nil
+}
+
+// findLauncher locates the launcher binary to install.
+func findLauncher() (string, error) {
+	candidates := []string{LauncherPath}
+	if exe, err := os.Executable(); err == nil {
+		candidates = append(candidates, filepath.Join(filepath.Dir(exe), "3rd-party-launcher"))
+	}
+	for _, c := range candidates {
+		if fi, err := os.Stat(c); err == nil && fi.Mode().IsRegular() {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("Unable to find the 3rd-party launcher in %s", strings.Join(candidates, " or "))
+}
+
+func copyFile(src string, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// installLauncher copies the launcher into bindir, keeping its modification
+// time to tell whether the copy is current.
+func installLauncher(bindir string) error {
+	launcher, err := findLauncher()
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(launcher)
+	if err != nil {
+		return err
+	}
+	dst := path.Join(bindir, LauncherName)
+	if err = copyFile(launcher, dst, 0755); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
+
+// launcherCurrent reports whether the launcher in bindir is a copy of the
+// installed one.
+func launcherCurrent(bindir string) bool {
+	launcher, err := findLauncher()
+	if err != nil {
+		return false
+	}
+	src, err := os.Stat(launcher)
+	if err != nil {
+		return false
+	}
+	dst, err := os.Stat(path.Join(bindir, LauncherName))
+	return err == nil && src.Size() == dst.Size() && src.ModTime().Equal(dst.ModTime())
+}
+
+// writeLauncherMetadata saves meta in bindir.
+func writeLauncherMetadata(bindir string, meta LauncherMetadata) error {
+	content, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(bindir, launcherMetadata), content, 0644)
+}
@@ -0,0 +1,270 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ManifestFile is a file entry of a swupd manifest.
+type ManifestFile struct {
+	Flags   string
+	Hash    string
+	Version string
+	Path    string
+}
+
+// Type returns the file type flag: 'F' file, 'D' directory, 'L' symlink or
+// 'M' manifest.
+func (f ManifestFile) Type() byte {
+	return f.Flags[0]
+}
+
+// IsDeleted reports whether the entry records a file removed from the bundle.
+func (f ManifestFile) IsDeleted() bool {
+	return f.Flags[1] == 'd'
+}
+
+// IsGhosted reports whether the entry is tracked but not managed by swupd.
+func (f ManifestFile) IsGhosted() bool {
+	return f.Flags[1] == 'g'
+}
+
+// Manifest is a parsed swupd manifest (either the MoM or a bundle manifest).
+type Manifest struct {
+	Format      string
+	Version     string
+	Previous    string
+	FileCount   int
+	Timestamp   time.Time
+	ContentSize int64
+	Includes    []string
+	Files       []ManifestFile
+}
+
+// File looks up the entry for path in the manifest.
+func (m Manifest) File(fpath string) (ManifestFile, bool) {
+	for _, f := range m.Files {
+		if f.Path == fpath {
+			return f, true
+		}
+	}
+	return ManifestFile{}, false
+}
+
+// ParseManifest reads a manifest in the format written by mixer-user-bundler.
+func ParseManifest(buffer io.Reader) (Manifest, error) {
+	var m Manifest
+	scanner := bufio.NewScanner(buffer)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		var err error
+		switch fields[0] {
+		case "MANIFEST":
+			if len(fields) > 1 {
+				m.Format = fields[1]
+			}
+		case "version:":
+			m.Version = fields[len(fields)-1]
+		case "previous:":
+			m.Previous = fields[len(fields)-1]
+		case "minversion:":
+		case "filecount:":
+			m.FileCount, err = strconv.Atoi(fields[len(fields)-1])
+		case "timestamp:":
+			var ts int64
+			if ts, err = strconv.ParseInt(fields[len(fields)-1], 10, 64); err == nil {
+				m.Timestamp = time.Unix(ts, 0)
+			}
+		case "contentsize:":
+			m.ContentSize, err = strconv.ParseInt(fields[len(fields)-1], 10, 64)
+		case "includes:", "includes", "also-add:":
+			m.Includes = append(m.Includes, fields[len(fields)-1])
+		default:
+			if len(fields) != 4 || len(fields[0]) != 4 {
+				return Manifest{}, fmt.Errorf("Invalid manifest entry at line %d: %s", line, text)
+			}
+			m.Files = append(m.Files, ManifestFile{Flags: fields[0], Hash: fields[1], Version: fields[2], Path: fields[3]})
+		}
+		if err != nil {
+			return Manifest{}, fmt.Errorf("Invalid manifest header at line %d: %s", line, text)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Manifest{}, err
+	}
+	if m.Format == "" {
+		return Manifest{}, fmt.Errorf("Missing MANIFEST header")
+	}
+	return m, nil
+}
+
+// openURI opens a file:// or http(s) uri for reading.
+func openURI(uri string) (io.ReadCloser, error) {
+	url, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	if url.Scheme == "file" {
+		return os.Open(url.Path)
+	}
+	resp, err := http.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", uri, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func readManifest(uri string) (Manifest, error) {
+	reader, err := openURI(uri)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer reader.Close()
+	m, err := ParseManifest(reader)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("Unable to parse manifest (%s): %s", uri, err)
+	}
+	return m, nil
+}
+
+// GetManifest fetches Manifest.<name> for version from the content at uri.
+func GetManifest(uri string, version string, name string) (Manifest, error) {
+	return readManifest(uri + path.Join("/", version, "Manifest."+name))
+}
+
+// GetBundleManifest fetches the MoM for version from uri and then the bundle
+// manifest it references.
+func GetBundleManifest(uri string, version string, name string) (Manifest, error) {
+	mom, err := GetManifest(uri, version, "MoM")
+	if err != nil {
+		return Manifest{}, err
+	}
+	entry, ok := mom.File(name)
+	if !ok {
+		return Manifest{}, fmt.Errorf("Bundle %s not found in MoM for version %s", name, version)
+	}
+	return GetManifest(uri, entry.Version, name)
+}
+
+// EligibleVersion finds the newest version after installed, starting from
+// latest and walking back through the previous versions recorded in the MoMs,
+// that was published at least age before now. It returns installed when no
+// newer version is old enough, along with when latest becomes eligible if it
+// isn't already.
+func EligibleVersion(uri string, installed string, latest string, age time.Duration, now time.Time) (string, time.Time, error) {
+	var until time.Time
+	current, _ := strconv.Atoi(installed)
+	version := latest
+	for {
+		if v, err := strconv.Atoi(version); err != nil || v <= current {
+			return installed, until, nil
+		}
+		mom, err := GetManifest(uri, version, "MoM")
+		if err != nil {
+			return installed, until, err
+		}
+		eligible := mom.Timestamp.Add(age)
+		if !now.Before(eligible) {
+			return version, until, nil
+		}
+		if version == latest {
+			until = eligible
+		}
+		version = mom.Previous
+	}
+}
+
+// GetCachedManifest loads the manifest of a bundle version from the copies
+// swupd keeps in the bundle state directory without using the network.
+func GetCachedManifest(pstatedir string, version string, name string) (Manifest, error) {
+	err := fmt.Errorf("No manifest for %s version %s in %s", name, version, pstatedir)
+	for _, dir := range []string{pstatedir, path.Join(pstatedir, "manifest")} {
+		local := "file://" + dir
+		mom, merr := GetManifest(local, version, "MoM")
+		if merr != nil {
+			continue
+		}
+		entry, ok := mom.File(name)
+		if !ok {
+			continue
+		}
+		m, merr := GetManifest(local, entry.Version, name)
+		if merr == nil {
+			return m, nil
+		}
+		err = merr
+	}
+	return Manifest{}, err
+}
+
+// GetInstalledManifest loads the manifest of the installed bundle version,
+// preferring the copies swupd cached in the bundle state directory and only
+// going to uri when they are missing.
+func GetInstalledManifest(pstatedir string, uri string, version string, name string) (Manifest, error) {
+	if m, err := GetCachedManifest(pstatedir, version, name); err == nil {
+		return m, nil
+	}
+	return GetBundleManifest(uri, version, name)
+}
+
+// DiffManifests compares the files of two bundle manifests returning the paths
+// added, removed and modified going from old to new.
+func DiffManifests(old Manifest, new Manifest) (added []string, removed []string, modified []string) {
+	oldFiles := make(map[string]ManifestFile)
+	for _, f := range old.Files {
+		if !f.IsDeleted() {
+			oldFiles[f.Path] = f
+		}
+	}
+	for _, f := range new.Files {
+		if f.IsDeleted() {
+			continue
+		}
+		prev, ok := oldFiles[f.Path]
+		delete(oldFiles, f.Path)
+		if !ok {
+			added = append(added, f.Path)
+		} else if prev.Hash != f.Hash || prev.Flags != f.Flags {
+			modified = append(modified, f.Path)
+		}
+	}
+	for _, f := range old.Files {
+		if _, ok := oldFiles[f.Path]; ok {
+			removed = append(removed, f.Path)
+		}
+	}
+	return added, removed, modified
+}
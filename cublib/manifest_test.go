@@ -0,0 +1,157 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseManifest(t *testing.T) {
+	fileHash := "5c9a2f4dbd1a6e2c4a8d8f63d0b2c8e5a1f1c2d3e4f5a6b7c8d9e0f1a2b3c4d5"
+	tests := []struct {
+		name    string
+		content string
+		want    Manifest
+		fail    bool
+	}{
+		{
+			name: "bundle",
+			content: "MANIFEST\t25\nversion:\t30\nprevious:\t20\nminversion:\t0\nfilecount:\t4\n" +
+				"timestamp:\t1600000000\ncontentsize:\t4096\nincludes:\tos-core\nincludes:\teditors\n\n" +
+				"D...\t" + swupdDirHash + "\t20\t/usr/bin\n" +
+				"F...\t" + fileHash + "\t30\t/usr/bin/test.sh\n" +
+				"L...\t" + fileHash + "\t30\t/usr/bin/link\n" +
+				".d..\t" + ZeroHash + "\t30\t/usr/bin/old\n",
+			want: Manifest{
+				Format:      "25",
+				Version:     "30",
+				Previous:    "20",
+				FileCount:   4,
+				Timestamp:   time.Unix(1600000000, 0),
+				ContentSize: 4096,
+				Includes:    []string{"os-core", "editors"},
+				Files: []ManifestFile{
+					{Flags: "D...", Hash: swupdDirHash, Version: "20", Path: "/usr/bin"},
+					{Flags: "F...", Hash: fileHash, Version: "30", Path: "/usr/bin/test.sh"},
+					{Flags: "L...", Hash: fileHash, Version: "30", Path: "/usr/bin/link"},
+					{Flags: ".d..", Hash: ZeroHash, Version: "30", Path: "/usr/bin/old"},
+				},
+			},
+		},
+		{
+			name:    "MoM",
+			content: "MANIFEST\t25\nversion:\t30\nprevious:\t20\nfilecount:\t1\ntimestamp:\t1600000000\ncontentsize:\t0\n\nM...\t" + fileHash + "\t30\ttest\n",
+			want: Manifest{
+				Format:    "25",
+				Version:   "30",
+				Previous:  "20",
+				FileCount: 1,
+				Timestamp: time.Unix(1600000000, 0),
+				Files:     []ManifestFile{{Flags: "M...", Hash: fileHash, Version: "30", Path: "test"}},
+			},
+		},
+		{
+			name:    "invalid header",
+			content: "MANIFEST\t25\nfilecount:\tmany\n",
+			fail:    true,
+		},
+		{
+			name:    "invalid timestamp",
+			content: "MANIFEST\t25\ntimestamp:\tyesterday\n",
+			fail:    true,
+		},
+		{
+			name:    "invalid entry",
+			content: "MANIFEST\t25\n\nF...\t" + fileHash + "\t/usr/bin/test.sh\n",
+			fail:    true,
+		},
+		{
+			name:    "invalid flags",
+			content: "MANIFEST\t25\n\nF.\t" + fileHash + "\t30\t/usr/bin/test.sh\n",
+			fail:    true,
+		},
+		{
+			name:    "missing format",
+			content: "version:\t30\n\nF...\t" + fileHash + "\t30\t/usr/bin/test.sh\n",
+			fail:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseManifest(strings.NewReader(tt.content))
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEligibleVersion(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1700000000, 0)
+	day := 24 * time.Hour
+	published := map[string]time.Time{
+		"20": now.Add(-10 * day),
+		"30": now.Add(-3 * day),
+		"40": now.Add(-time.Hour),
+	}
+	previous := map[string]string{"20": "10", "30": "20", "40": "30"}
+	for version, ts := range published {
+		mom := fmt.Sprintf("MANIFEST\t25\nversion:\t%s\nprevious:\t%s\nfilecount:\t0\ntimestamp:\t%d\ncontentsize:\t0\n", version, previous[version], ts.Unix())
+		mkfile(t, path.Join(dir, version, "Manifest.MoM"), mom, 0644)
+	}
+	uri := "file://" + dir
+
+	tests := []struct {
+		name      string
+		installed string
+		latest    string
+		age       time.Duration
+		want      string
+		until     time.Time
+		fail      bool
+	}{
+		{name: "no deferral", installed: "10", latest: "40", want: "40"},
+		{name: "latest too new", installed: "10", latest: "40", age: 2 * day, want: "30", until: published["40"].Add(2 * day)},
+		{name: "walks back", installed: "10", latest: "40", age: 5 * day, want: "20", until: published["40"].Add(5 * day)},
+		{name: "nothing old enough", installed: "10", latest: "40", age: 30 * day, want: "10", until: published["40"].Add(30 * day)},
+		{name: "installed is newest eligible", installed: "30", latest: "40", age: 5 * day, want: "30", until: published["40"].Add(5 * day)},
+		{name: "up to date", installed: "40", latest: "40", age: 5 * day, want: "40"},
+		{name: "invalid latest", installed: "10", latest: "next", age: 5 * day, want: "10"},
+		{name: "missing MoM", installed: "10", latest: "50", age: 5 * day, want: "10", fail: true},
+	}
+
+	for _, tt := range tests {
+		got, until, err := EligibleVersion(uri, tt.installed, tt.latest, tt.age, now)
+		if tt.fail != (err != nil) {
+			t.Errorf("%s: unexpected error result: %v", tt.name, err)
+		}
+		if got != tt.want || !until.Equal(tt.until) {
+			t.Errorf("%s: got %s until %s, want %s until %s", tt.name, got, until, tt.want, tt.until)
+		}
+	}
+}
@@ -15,92 +15,437 @@
 package cublib
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 )
 
-func setupBins(statedir string, contentdir string, installdir string, bins []string) error {
-	scriptTemplate := `#!/bin/bash
+// shellQuote quotes s as a single word for sh.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
 
-export PATH=%s:%s
-export LD_LIBRARY_PATH=%s:%s
-`
-	internalBinPath := fmt.Sprintf("%s/usr/bin", installdir)
-	internalLdPath := fmt.Sprintf("%s/usr/lib64", installdir)
-	targetPath := path.Join(contentdir, ".bin")
-	err := os.MkdirAll(targetPath, 0755)
-	if err != nil {
-		return err
+// BinMatch is what a Bin pattern matched in the installed bundle content, the
+// applications it exported or, for an exclude, the ones it left out.
+type BinMatch struct {
+	Pattern string   `json:"pattern" toml:"pattern"`
+	Matches []string `json:"matches" toml:"matches"`
+}
+
+// globApps returns the paths inside installdir of the applications matching
+// pattern, which are anything but directories.
+func globApps(installdir string, pattern string) []string {
+	apps := []string{}
+	found, _ := filepath.Glob(path.Join(installdir, pattern))
+	for _, f := range found {
+		fi, err := os.Lstat(f)
+		if err != nil || fi.IsDir() || (fi.Mode().IsRegular() && fi.Mode()&0111 == 0) {
+			continue
+		}
+		apps = append(apps, strings.TrimPrefix(f, installdir))
 	}
-	envPath := os.Getenv("PATH")
-	envLdPath := os.Getenv("LD_LIBRARY_PATH")
-	binScript := fmt.Sprintf(scriptTemplate, internalBinPath, envPath, internalLdPath, envLdPath)
-	for _, b := range bins {
-		if _, err = os.Lstat(path.Join(installdir, b)); err != nil {
-			log.Printf("WARNING: Application %s set to be installed but not found in %s", b, installdir)
+	return apps
+}
+
+// ResolveApps lists the applications exported from the bundle installed in
+// installdir, expanding the Bin patterns of config against its content. An
+// exclude, a pattern starting with "!", drops the applications it matches from
+// the expanded patterns, and applications exported by name take precedence
+// over matches. What each pattern matched is returned in config order.
+// Entries of config with problems are left out with a warning.
+func ResolveApps(installdir string, config BundleConfig) ([]AppConfig, []BinMatch) {
+	apps, problems := config.apps()
+	patterns, patternProblems := config.patterns()
+	for _, err := range append(problems, patternProblems...) {
+		log.Printf("WARNING: Ignoring part of the %s config: %s", config.Name, err)
+	}
+	exported := make(map[string]bool)
+	for _, app := range apps {
+		exported[app.Name] = true
+	}
+	matches := []BinMatch{}
+	excludes := make(map[string]int)
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "!") {
+			excludes[p[1:]] = len(matches)
+		}
+		matches = append(matches, BinMatch{Pattern: p, Matches: []string{}})
+	}
+	for i, m := range matches {
+		if strings.HasPrefix(m.Pattern, "!") {
 			continue
 		}
-		fullBinScript := fmt.Sprintf("%s%s \"$@\"\n", binScript, path.Join(installdir, b))
-		err = ioutil.WriteFile(path.Join(targetPath, path.Base(b)), []byte(fullBinScript), 0755)
-		if err != nil {
+		for _, target := range globApps(installdir, m.Pattern) {
+			excluded := false
+			for exclude, j := range excludes {
+				if ok, _ := path.Match(exclude, target); ok {
+					matches[j].Matches = append(matches[j].Matches, target)
+					excluded = true
+				}
+			}
+			if excluded || exported[path.Base(target)] {
+				continue
+			}
+			exported[path.Base(target)] = true
+			matches[i].Matches = append(matches[i].Matches, target)
+			apps = append(apps, AppConfig{Name: path.Base(target), Target: target})
+		}
+	}
+	return apps, matches
+}
+
+// reportExports logs what the bin patterns of each bundle matched and the
+// collisions between exported commands.
+func reportExports(contentdir string, exports []BundleExports, collisions []Collision) {
+	for _, e := range exports {
+		name := e.Bundle.Config.Bundle.Name
+		for _, m := range e.Matches {
+			if strings.HasPrefix(m.Pattern, "!") {
+				if len(m.Matches) > 0 {
+					log.Printf("%s: %s excluded %s", name, m.Pattern, strings.Join(m.Matches, " "))
+				}
+			} else if len(m.Matches) == 0 {
+				log.Printf("WARNING: %s: %s matched no applications in %s", name, m.Pattern, e.Bundle.ChrootDir(contentdir))
+			} else {
+				log.Printf("%s: %s matched %s", name, m.Pattern, strings.Join(m.Matches, " "))
+			}
+		}
+	}
+	for _, c := range collisions {
+		if c.Contested() {
+			var names []string
+			for _, p := range c.Providers {
+				names = append(names, p.Name)
+			}
+			log.Printf("WARNING: %s is exported by %s, using %s (%s)", c.Command, strings.Join(names, " and "), c.Providers[0].Name, c.Reason)
+		}
+		if c.Host != "" {
+			log.Printf("WARNING: %s exported by %s is shadowed by %s", c.Command, c.Providers[0].Name, c.Host)
+		}
+	}
+}
+
+// launcherApps records in meta how to run the applications apps of the
+// bundle installed in installdir, leaving out the ones missing from it.
+func launcherApps(installdir string, apps []AppConfig, meta *LauncherMetadata) {
+	for _, app := range apps {
+		if _, err := os.Lstat(path.Join(installdir, app.Target)); err != nil {
+			log.Printf("WARNING: Application %s set to be installed but not found in %s", app.Target, installdir)
+			continue
+		}
+		la := LauncherApp{
+			ID:            path.Base(installdir),
+			App:           app.Target,
+			Target:        path.Join(installdir, app.Target),
+			Path:          []string{path.Join(installdir, "usr", "bin")},
+			LdLibraryPath: []string{path.Join(installdir, "usr", "lib64")},
+		}
+		// Empty settings are left unset as they are when read back
+		if len(app.Args) > 0 {
+			la.Args = app.Args
+		}
+		if len(app.Env) > 0 {
+			la.Env = app.Env
+		}
+		if app.WorkDir != "" {
+			la.WorkDir = path.Join(installdir, app.WorkDir)
+		}
+		meta.Apps[app.Name] = la
+	}
+}
+
+// reuseApps copies the applications apps of the unchanged bundle id from the
+// previous export prev to meta, returning false when prev doesn't have all
+// of them for the bundle.
+func reuseApps(prev LauncherMetadata, id string, apps []AppConfig, meta *LauncherMetadata) bool {
+	for _, app := range apps {
+		if la, ok := prev.Apps[app.Name]; !ok || la.ID != id {
+			return false
+		}
+	}
+	for _, app := range apps {
+		meta.Apps[app.Name] = prev.Apps[app.Name]
+	}
+	return true
+}
+
+// bundleFingerprint identifies the config, installed version and docs policy
+// of the bundle e, the exports of bundles whose fingerprint didn't change are
+// reused. It is empty when the config or version can't be read.
+func bundleFingerprint(contentdir string, e BundleExports) string {
+	b := e.Bundle
+	config, err := ioutil.ReadFile(b.ConfigPath(contentdir))
+	if err != nil {
+		return ""
+	}
+	version, err := GetInstalledVersion(b.ChrootDir(contentdir))
+	if err != nil {
+		return ""
+	}
+	h := sha256.New()
+	h.Write(config)
+	h.Write([]byte("\x00" + version + "\x00" + e.Docs))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// exportsDir holds the generations of the exported content under contentdir.
+// Every exported item is a symlink into the current generation so replacing
+// the generation replaces all of an item at once.
+const exportsDir = ".exports"
+
+// exportedItems are the directories of a generation linked from contentdir.
+var exportedItems = []string{"bin", "share", "units"}
+
+// exportsCurrent reports whether the exported content is already the one
+// described by meta.
+func exportsCurrent(contentdir string, prev LauncherMetadata, meta LauncherMetadata) bool {
+	for _, item := range exportedItems {
+		if target, err := os.Readlink(path.Join(contentdir, item)); err != nil || !strings.HasPrefix(target, exportsDir+"/") {
+			return false
+		}
+	}
+	if !reflect.DeepEqual(prev.Apps, meta.Apps) || !reflect.DeepEqual(prev.Bundles, meta.Bundles) {
+		return false
+	}
+	bindir := path.Join(contentdir, "bin")
+	for name := range meta.Apps {
+		if target, err := os.Readlink(path.Join(bindir, name)); err != nil || target != LauncherName {
+			return false
+		}
+	}
+	return len(meta.Apps) == 0 || launcherCurrent(bindir)
+}
+
+// reportChanges logs the commands added, removed and changed since prev.
+func reportChanges(prev LauncherMetadata, meta LauncherMetadata) {
+	var added, removed, changed []string
+	for name, la := range meta.Apps {
+		if old, ok := prev.Apps[name]; !ok {
+			added = append(added, name)
+		} else if !reflect.DeepEqual(old, la) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range prev.Apps {
+		if _, ok := meta.Apps[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	for _, c := range []struct {
+		what  string
+		names []string
+	}{{"added", added}, {"removed", removed}, {"changed", changed}} {
+		if len(c.names) > 0 {
+			sort.Strings(c.names)
+			log.Printf("Exported commands %s: %s", c.what, strings.Join(c.names, " "))
+		}
+	}
+}
+
+// writeGeneration creates a generation of the exported content of exports
+// with the commands in meta, returning its path relative to contentdir.
+func writeGeneration(contentdir string, exports []BundleExports, meta LauncherMetadata) (string, error) {
+	gens := path.Join(contentdir, exportsDir)
+	if err := os.MkdirAll(gens, 0755); err != nil {
+		return "", err
+	}
+	gen, err := ioutil.TempDir(gens, "gen-")
+	if err != nil {
+		return "", err
+	}
+	if err = populateGeneration(contentdir, gen, exports, meta); err != nil {
+		os.RemoveAll(gen)
+		return "", err
+	}
+	return path.Join(exportsDir, path.Base(gen)), nil
+}
+
+func populateGeneration(contentdir string, gen string, exports []BundleExports, meta LauncherMetadata) error {
+	if err := os.Chmod(gen, 0755); err != nil {
+		return err
+	}
+	bindir := path.Join(gen, "bin")
+	if err := os.Mkdir(bindir, 0755); err != nil {
+		return err
+	}
+	for name := range meta.Apps {
+		if err := os.Symlink(LauncherName, path.Join(bindir, name)); err != nil {
 			return err
 		}
 	}
+	if len(meta.Apps) > 0 {
+		if err := installLauncher(bindir); err != nil {
+			return fmt.Errorf("Unable to install launcher: %s", err)
+		}
+	}
+	if err := writeLauncherMetadata(bindir, meta); err != nil {
+		return err
+	}
+	share := newShareTree(path.Join(gen, "share"))
+	if err := os.Mkdir(share.dir, 0755); err != nil {
+		return err
+	}
+	for _, e := range exports {
+		if err := share.exportDesktop(contentdir, e, meta); err != nil {
+			log.Printf("WARNING: Unable to export desktop entries of %s: %s", e.Bundle.Config.Bundle.Name, err)
+		}
+		if err := share.exportDocs(contentdir, e, meta); err != nil {
+			log.Printf("WARNING: Unable to export man pages and completions of %s: %s", e.Bundle.Config.Bundle.Name, err)
+		}
+	}
+	share.updateCaches()
+	units := newShareTree(path.Join(gen, "units"))
+	if err := os.Mkdir(units.dir, 0755); err != nil {
+		return err
+	}
+	for _, e := range exports {
+		if err := units.exportUnits(contentdir, e); err != nil {
+			log.Printf("WARNING: Unable to export units of %s: %s", e.Bundle.Config.Bundle.Name, err)
+		}
+	}
 	return nil
 }
 
-func stageContent(contentdir string) error {
-	items := []string{"bin"}
-	for _, item := range items {
-		old := path.Join(contentdir, item)
-		new := path.Join(contentdir, fmt.Sprintf(".%s", item))
-		err := os.RemoveAll(old)
-		if err != nil && !os.IsNotExist(err) {
+// stageContent points the exported items of contentdir to the generation gen
+// and removes the generations older than the one it replaces, which is only
+// removed by the next run as processes may still be using its content. Each
+// item is swapped atomically so its content never goes missing, including the
+// first time when it is still a directory created by an older version.
+func stageContent(contentdir string, gen string) error {
+	keep := map[string]bool{path.Base(gen): true}
+	if target, err := os.Readlink(path.Join(contentdir, exportedItems[0])); err == nil && strings.HasPrefix(target, exportsDir+"/") {
+		keep[path.Base(path.Dir(target))] = true
+	}
+	for _, item := range exportedItems {
+		link := path.Join(contentdir, item)
+		tmp := path.Join(contentdir, fmt.Sprintf(".%s.new", item))
+		if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
 			return err
 		}
-		_, err = os.Stat(new)
-		if os.IsNotExist(err) {
-			continue
+		if err := os.Symlink(path.Join(gen, item), tmp); err != nil {
+			return err
 		}
-		err = os.Rename(new, old)
-		if err != nil {
+		if fi, err := os.Lstat(link); err == nil && fi.IsDir() {
+			if err = exchange(tmp, link); err == nil {
+				if err = os.RemoveAll(tmp); err != nil {
+					return err
+				}
+				continue
+			}
+			// Without renameat2 the directory has to be removed first
+			log.Printf("WARNING: Unable to swap %s atomically: %s", link, err)
+			if err = os.RemoveAll(link); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(tmp, link); err != nil {
+			return err
+		}
+	}
+	// Older versions staged the content next to the items
+	for _, item := range exportedItems {
+		if err := os.RemoveAll(path.Join(contentdir, fmt.Sprintf(".%s", item))); err != nil {
 			return err
 		}
 	}
+	gens, err := ioutil.ReadDir(path.Join(contentdir, exportsDir))
+	if err != nil {
+		return err
+	}
+	for _, g := range gens {
+		if !keep[g.Name()] {
+			if err = os.RemoveAll(path.Join(contentdir, exportsDir, g.Name())); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-func PostProcess(statedir string, contentdir string) error {
-	pstatedir := path.Join(statedir, "3rd-party")
-	chrootdir := path.Join(contentdir, "chroot")
-	dlist, err := ioutil.ReadDir(chrootdir)
+// PostProcess regenerates the exported content of every installed bundle
+// with the settings read from settingsPath. The content of bundles that
+// didn't change since the last run is reused and nothing is replaced when
+// no bundle changed.
+func PostProcess(statedir string, contentdir string, settingsPath string) error {
+	settings, err := LoadSettings(settingsPath)
 	if err != nil {
-		return fmt.Errorf("Unable to read 3rd-party content directory (%s): %s", pstatedir, err)
+		return err
 	}
+	exports, collisions, err := ResolveExports(statedir, contentdir, settings)
+	if err != nil {
+		return err
+	}
+	reportExports(contentdir, exports, collisions)
+	updateProfiles(contentdir, settings, len(exports) > 0)
 
-	for _, p := range dlist {
-		// chroot dir should only be chroot directories and conf files so skip the conf files
-		// as it is easier to make those names from the directory names
-		if ext := filepath.Ext(p.Name()); ext != "" {
-			continue
-		}
-		confPath := "file://" + path.Join(chrootdir, p.Name()) + ".toml"
-		conf, err := GetConfig(confPath)
-		if err != nil {
-			log.Printf("WARNING: Unable to read 3rd party config (%s): %s", confPath, err)
+	prev, err := ReadLauncherMetadata(path.Join(contentdir, "bin"))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("WARNING: %s, regenerating all exported content", err)
+	}
+	meta := LauncherMetadata{Apps: make(map[string]LauncherApp), Bundles: make(map[string]string)}
+	for _, e := range exports {
+		fingerprint := bundleFingerprint(contentdir, e)
+		meta.Bundles[e.Bundle.ID] = fingerprint
+		if fingerprint != "" && prev.Bundles[e.Bundle.ID] == fingerprint && reuseApps(prev, e.Bundle.ID, e.Apps, &meta) {
 			continue
 		}
-		if err = setupBins(pstatedir, contentdir, path.Join(chrootdir, p.Name()), conf.Bundle.Bin); err != nil {
-			log.Printf("WARNING: Unable to create bin scripts for %s: %s", conf.Bundle.Name, err)
-		}
+		launcherApps(e.Bundle.ChrootDir(contentdir), e.Apps, &meta)
 	}
-	if err = stageContent(contentdir); err != nil {
+	if exportsCurrent(contentdir, prev, meta) {
+		return nil
+	}
+	reportChanges(prev, meta)
+
+	units := exportedUnits(contentdir)
+	gen, err := writeGeneration(contentdir, exports, meta)
+	if err != nil {
+		return fmt.Errorf("Unable to export applications to %s: %s", contentdir, err)
+	}
+	if err = stageContent(contentdir, gen); err != nil {
 		return fmt.Errorf("User content not staged successfully to %s: %s", contentdir, err)
 	}
+	updateUnits(contentdir, units)
 
 	return nil
 }
+
+// GetExportOwner finds which bundle provides the exported command name and the
+// application path inside its chroot the command runs.
+func GetExportOwner(contentdir string, bundles []Bundle, name string) (Bundle, string, error) {
+	bindir := path.Join(contentdir, "bin")
+	if meta, err := ReadLauncherMetadata(bindir); err == nil {
+		if app, ok := meta.Apps[name]; ok {
+			for _, b := range bundles {
+				if b.ID == app.ID {
+					return b, app.App, nil
+				}
+			}
+		}
+		return Bundle{}, "", fmt.Errorf("No installed bundle exports %s", name)
+	}
+	// Exported applications used to be scripts running the application path
+	wrapper, err := ioutil.ReadFile(path.Join(bindir, name))
+	if err != nil {
+		return Bundle{}, "", err
+	}
+	for _, b := range bundles {
+		for _, app := range b.Config.Bundle.Apps() {
+			if app.Name != name {
+				continue
+			}
+			target := path.Join(b.ChrootDir(contentdir), app.Target)
+			if strings.Contains(string(wrapper), shellQuote(target)+" ") || strings.Contains(string(wrapper), target+" ") {
+				return b, app.Target, nil
+			}
+		}
+	}
+	return Bundle{}, "", fmt.Errorf("No installed bundle exports %s", name)
+}
@@ -0,0 +1,134 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// makeBinContent creates a /usr/bin with executables, a file that isn't
+// executable, a symlink and a directory in installdir.
+func makeBinContent(t *testing.T, installdir string) {
+	bindir := path.Join(installdir, "usr", "bin")
+	for _, name := range []string{"tool", "vendor-a", "vendor-a-debug", "vendor-b"} {
+		mkfile(t, path.Join(bindir, name), "#!/bin/sh\n", 0755)
+	}
+	mkfile(t, path.Join(bindir, "vendor-doc"), "#!/bin/sh\n", 0644)
+	if err := os.Mkdir(path.Join(bindir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("tool", path.Join(bindir, "link")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveApps(t *testing.T) {
+	installdir := t.TempDir()
+	makeBinContent(t, installdir)
+
+	tests := []struct {
+		name    string
+		config  BundleConfig
+		apps    []AppConfig
+		matches []BinMatch
+	}{
+		{
+			name:   "paths only",
+			config: BundleConfig{Bin: []string{"/usr/bin/tool", "/usr/bin/missing"}},
+			apps: []AppConfig{
+				{Name: "tool", Target: "/usr/bin/tool"},
+				{Name: "missing", Target: "/usr/bin/missing"},
+			},
+			matches: []BinMatch{},
+		},
+		{
+			name:   "pattern",
+			config: BundleConfig{Bin: []string{"/usr/bin/vendor-*"}},
+			apps: []AppConfig{
+				{Name: "vendor-a", Target: "/usr/bin/vendor-a"},
+				{Name: "vendor-a-debug", Target: "/usr/bin/vendor-a-debug"},
+				{Name: "vendor-b", Target: "/usr/bin/vendor-b"},
+			},
+			matches: []BinMatch{
+				{Pattern: "/usr/bin/vendor-*", Matches: []string{"/usr/bin/vendor-a", "/usr/bin/vendor-a-debug", "/usr/bin/vendor-b"}},
+			},
+		},
+		{
+			name:   "exclude",
+			config: BundleConfig{Bin: []string{"!/usr/bin/*-debug", "/usr/bin/vendor-*"}},
+			apps: []AppConfig{
+				{Name: "vendor-a", Target: "/usr/bin/vendor-a"},
+				{Name: "vendor-b", Target: "/usr/bin/vendor-b"},
+			},
+			matches: []BinMatch{
+				{Pattern: "!/usr/bin/*-debug", Matches: []string{"/usr/bin/vendor-a-debug"}},
+				{Pattern: "/usr/bin/vendor-*", Matches: []string{"/usr/bin/vendor-a", "/usr/bin/vendor-b"}},
+			},
+		},
+		{
+			name: "named apps take precedence",
+			config: BundleConfig{
+				Bin: []string{"/usr/bin/tool", "/usr/bin/*"},
+				App: []AppConfig{{Name: "vendor-a", Target: "/opt/vendor/a"}},
+			},
+			apps: []AppConfig{
+				{Name: "tool", Target: "/usr/bin/tool"},
+				{Name: "vendor-a", Target: "/opt/vendor/a"},
+				{Name: "link", Target: "/usr/bin/link"},
+				{Name: "vendor-a-debug", Target: "/usr/bin/vendor-a-debug"},
+				{Name: "vendor-b", Target: "/usr/bin/vendor-b"},
+			},
+			matches: []BinMatch{
+				{Pattern: "/usr/bin/*", Matches: []string{"/usr/bin/link", "/usr/bin/vendor-a-debug", "/usr/bin/vendor-b"}},
+			},
+		},
+		{
+			name: "first of duplicates and valid apps only",
+			config: BundleConfig{
+				Bin: []string{"/usr/bin/tool", "/usr/sbin/tool"},
+				App: []AppConfig{{Name: "tool", Target: "/opt/tool"}, {Name: "a/b", Target: "/usr/bin/vendor-a"}, {Name: "none"}},
+			},
+			apps:    []AppConfig{{Name: "tool", Target: "/usr/bin/tool"}},
+			matches: []BinMatch{},
+		},
+		{
+			name:    "invalid entries",
+			config:  BundleConfig{Bin: []string{"usr/bin/tool", "/usr/bin/../bin/tool", "/usr/bin/[", "!../*", "/usr/bin/vendor-b"}},
+			apps:    []AppConfig{{Name: "vendor-b", Target: "/usr/bin/vendor-b"}},
+			matches: []BinMatch{},
+		},
+		{
+			name:   "no match",
+			config: BundleConfig{Bin: []string{"/opt/*"}},
+			apps:   []AppConfig{},
+			matches: []BinMatch{
+				{Pattern: "/opt/*", Matches: []string{}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		apps, matches := ResolveApps(installdir, tt.config)
+		if !reflect.DeepEqual(apps, tt.apps) {
+			t.Errorf("%s: got apps %v, want %v", tt.name, apps, tt.apps)
+		}
+		if !reflect.DeepEqual(matches, tt.matches) {
+			t.Errorf("%s: got matches %v, want %v", tt.name, matches, tt.matches)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// DefaultContentDir is where 3rd-party content is installed unless another
+// contentdir is given.
+const DefaultContentDir = "/opt/3rd-party"
+
+// Default directories of the shell profile snippets adding the exported
+// content of DefaultContentDir to user sessions.
+const (
+	ProfileDir  = "/etc/profile.d"
+	FishConfDir = "/etc/fish/conf.d"
+)
+
+const profileHeader = "# Generated by 3rd-party-post for the 3rd-party content in %s, changes\n# are overwritten.\n"
+
+// fishQuote quotes s as a single word for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// shAppend appends dir to the list variable name unless it is already in it,
+// with start being the expansion of the current value followed by a colon.
+func shAppend(name string, start string, dir string) string {
+	return fmt.Sprintf("case \":${%s-}:\" in\n*:%s:*) ;;\n*) %s=\"%s\"%s; export %s ;;\nesac\n", name, shellQuote(dir), name, start, shellQuote(dir), name)
+}
+
+// shProfile is the profile snippet for sh compatible shells. The bin and man
+// directories are appended so host commands and man pages win, an unset
+// MANPATH is kept empty ahead of the exported man pages for man to search
+// its default directories first and an unset XDG_DATA_DIRS gets its default
+// value.
+func shProfile(contentdir string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, profileHeader, contentdir)
+	b.WriteString(shAppend("PATH", "${PATH:+$PATH:}", path.Join(contentdir, "bin")))
+	b.WriteString(shAppend("MANPATH", "${MANPATH-}:", path.Join(contentdir, "share", "man")))
+	b.WriteString(shAppend("XDG_DATA_DIRS", "${XDG_DATA_DIRS:-/usr/local/share:/usr/share}:", path.Join(contentdir, "share")))
+	// zsh doesn't look for completions in XDG_DATA_DIRS, its FPATH is tied
+	// to fpath
+	fpath := shellQuote(path.Join(contentdir, "share", "zsh", "site-functions"))
+	fmt.Fprintf(&b, "if [ -n \"${ZSH_VERSION-}\" ]; then\n\tcase \":${FPATH-}:\" in\n\t*:%s:*) ;;\n\t*) FPATH=\"${FPATH:+$FPATH:}\"%s ;;\n\tesac\nfi\n", fpath, fpath)
+	return b.Bytes()
+}
+
+// fishProfile is the profile snippet for fish, which reads its vendor
+// completions from XDG_DATA_DIRS before running the snippet so the exported
+// ones are added to fish_complete_path directly.
+func fishProfile(contentdir string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, profileHeader, contentdir)
+	bin := fishQuote(path.Join(contentdir, "bin"))
+	fmt.Fprintf(&b, "contains -- %s $PATH; or set -gx PATH $PATH %s\n", bin, bin)
+	man := path.Join(contentdir, "share", "man")
+	fmt.Fprintf(&b, "string match -q -- %s \":$MANPATH:\"; or set -gx MANPATH \"$MANPATH:\"%s\n", fishQuote("*:"+man+":*"), fishQuote(man))
+	share := path.Join(contentdir, "share")
+	b.WriteString("set -q XDG_DATA_DIRS[1]; or set -gx XDG_DATA_DIRS /usr/local/share:/usr/share\n")
+	fmt.Fprintf(&b, "string match -q -- %s \":$XDG_DATA_DIRS:\"; or set -gx XDG_DATA_DIRS \"$XDG_DATA_DIRS:\"%s\n", fishQuote("*:"+share+":*"), fishQuote(share))
+	completions := fishQuote(path.Join(contentdir, "share", "fish", "vendor_completions.d"))
+	fmt.Fprintf(&b, "contains -- %s $fish_complete_path; or set -a fish_complete_path %s\n", completions, completions)
+	return b.Bytes()
+}
+
+// writeProfile replaces the snippet at p with content unless it already has
+// it.
+func writeProfile(p string, content []byte) error {
+	if old, err := ioutil.ReadFile(p); err == nil && bytes.Equal(old, content) {
+		return nil
+	}
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp := path.Join(path.Dir(p), "."+path.Base(p)+".new")
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// updateProfiles writes the shell profile snippets for contentdir, or removes
+// them when there are no bundles left to export.
+func updateProfiles(contentdir string, settings Settings, bundles bool) {
+	profiles := []struct {
+		dir     string
+		name    string
+		content []byte
+	}{
+		{settings.ProfileDirFor(contentdir), "3rd-party.sh", shProfile(contentdir)},
+		{settings.FishDirFor(contentdir), "3rd-party.fish", fishProfile(contentdir)},
+	}
+	for _, p := range profiles {
+		if p.dir == "" {
+			continue
+		}
+		profile := path.Join(p.dir, p.name)
+		if !bundles {
+			if err := os.Remove(profile); err != nil && !os.IsNotExist(err) {
+				log.Printf("WARNING: Unable to remove shell profile %s: %s", profile, err)
+			}
+			continue
+		}
+		if err := writeProfile(profile, p.content); err != nil {
+			log.Printf("WARNING: Unable to write shell profile %s: %s", profile, err)
+		}
+	}
+}
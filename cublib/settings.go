@@ -0,0 +1,279 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Locations of the swupd-3rd-party settings, the administrator's file in /etc
+// takes precedence over the distribution default.
+const (
+	SettingsPath        = "/etc/swupd/3rd-party.toml"
+	DefaultSettingsPath = "/usr/share/defaults/swupd/3rd-party.toml"
+)
+
+// Duration is a time.Duration written as a string such as "72h" in settings.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	if err == nil && d.Duration < 0 {
+		err = fmt.Errorf("negative duration %s", text)
+	}
+	return err
+}
+
+// Weekday is a time.Weekday written as its English name, full or
+// abbreviated, in settings.
+type Weekday struct {
+	time.Weekday
+}
+
+func (d *Weekday) UnmarshalText(text []byte) error {
+	for w := time.Sunday; w <= time.Saturday; w++ {
+		if strings.EqualFold(string(text), w.String()) || strings.EqualFold(string(text), w.String()[:3]) {
+			d.Weekday = w
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid weekday %s", text)
+}
+
+// ClockTime is a time of day written as "15:04" in settings, stored as
+// minutes since midnight.
+type ClockTime struct {
+	Minutes int
+}
+
+func (c *ClockTime) UnmarshalText(text []byte) error {
+	t, err := time.Parse("15:04", string(text))
+	if err != nil {
+		return fmt.Errorf("invalid time of day %s, expected HH:MM", text)
+	}
+	c.Minutes = t.Hour()*60 + t.Minute()
+	return nil
+}
+
+// Window is a maintenance window in local time, from Start to End on each of
+// Days, or every day when Days is empty. A window ending at or before its
+// start ends on the following day.
+type Window struct {
+	Days  []Weekday `toml:"days"`
+	Start ClockTime `toml:"start"`
+	End   ClockTime `toml:"end"`
+}
+
+func (w Window) onDay(d time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, day := range w.Days {
+		if day.Weekday == d {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains reports whether t falls in the window.
+func (w Window) Contains(t time.Time) bool {
+	t = t.Local()
+	m := t.Hour()*60 + t.Minute()
+	if w.Start.Minutes < w.End.Minutes {
+		return w.onDay(t.Weekday()) && m >= w.Start.Minutes && m < w.End.Minutes
+	}
+	yesterday := (t.Weekday() + 6) % 7
+	return (w.onDay(t.Weekday()) && m >= w.Start.Minutes) || (w.onDay(yesterday) && m < w.End.Minutes)
+}
+
+// Which man pages and shell completions of a bundle are exported, the ones of
+// the commands exported from it or all of them.
+const (
+	DocsCommands = "commands"
+	DocsAll      = "all"
+)
+
+// BundleSettings overrides the global settings for the bundles matching Name,
+// which is a bundle name or ID, and URL when it is set.
+type BundleSettings struct {
+	Name  string    `toml:"name"`
+	URL   string    `toml:"url"`
+	Defer *Duration `toml:"defer"`
+	Docs  string    `toml:"docs"`
+}
+
+// Settings is the administrator configuration of swupd-3rd-party.
+type Settings struct {
+	Defer      Duration         `toml:"defer"`
+	Priority   []string         `toml:"priority"`
+	Docs       string           `toml:"docs"`
+	ProfileDir string           `toml:"profiledir"`
+	FishDir    string           `toml:"fishdir"`
+	Windows    []Window         `toml:"window"`
+	Bundles    []BundleSettings `toml:"bundle"`
+}
+
+// LoadSettings reads the settings from settingsPath, or from the first of
+// SettingsPath and DefaultSettingsPath that exists when it is empty. Without
+// any settings file the defaults are used.
+func LoadSettings(settingsPath string) (Settings, error) {
+	var settings Settings
+	paths := []string{settingsPath}
+	if settingsPath == "" {
+		paths = []string{SettingsPath, DefaultSettingsPath}
+	}
+	for _, p := range paths {
+		if _, err := toml.DecodeFile(p, &settings); err != nil {
+			if os.IsNotExist(err) && settingsPath == "" {
+				continue
+			}
+			return Settings{}, fmt.Errorf("Unable to read 3rd-party settings (%s): %s", p, err)
+		}
+		if err := settings.validate(); err != nil {
+			return Settings{}, fmt.Errorf("Invalid 3rd-party settings (%s): %s", p, err)
+		}
+		break
+	}
+	return settings, nil
+}
+
+func validDocs(docs string) bool {
+	return docs == "" || docs == DocsCommands || docs == DocsAll
+}
+
+func (s Settings) validate() error {
+	if !validDocs(s.Docs) {
+		return fmt.Errorf("invalid docs %s, expected %s or %s", s.Docs, DocsCommands, DocsAll)
+	}
+	for _, bs := range s.Bundles {
+		if !validDocs(bs.Docs) {
+			return fmt.Errorf("invalid docs %s for bundle %s, expected %s or %s", bs.Docs, bs.Name, DocsCommands, DocsAll)
+		}
+	}
+	return nil
+}
+
+// bundle returns the overrides for b, nil when there are none.
+func (s Settings) bundle(b Bundle) *BundleSettings {
+	for i, bs := range s.Bundles {
+		if bs.Name != b.ID && bs.Name != b.Config.Bundle.Name {
+			continue
+		}
+		if bs.URL != "" && bs.URL != b.Config.Bundle.URL {
+			continue
+		}
+		return &s.Bundles[i]
+	}
+	return nil
+}
+
+// rank is the position of b, by name or ID, in the priority list for
+// exporting contested commands. Bundles not listed come after all others.
+func (s Settings) rank(b Bundle) int {
+	for i, p := range s.Priority {
+		if p == b.ID || p == b.Config.Bundle.Name {
+			return i
+		}
+	}
+	return len(s.Priority)
+}
+
+// DeferFor is how old a version must be before b is updated to it.
+func (s Settings) DeferFor(b Bundle) time.Duration {
+	if bs := s.bundle(b); bs != nil && bs.Defer != nil {
+		return bs.Defer.Duration
+	}
+	return s.Defer.Duration
+}
+
+// DocsFor is which man pages and shell completions of b are exported.
+func (s Settings) DocsFor(b Bundle) string {
+	if bs := s.bundle(b); bs != nil && bs.Docs != "" {
+		return bs.Docs
+	}
+	if s.Docs != "" {
+		return s.Docs
+	}
+	return DocsCommands
+}
+
+// profileDir is where the profile snippet for contentdir is written, dir when
+// it is set. The default directory is only used for the default contentdir
+// as the snippet there is the system's, empty when there is none to write.
+func profileDir(dir string, defaultDir string, contentdir string) string {
+	if dir != "" {
+		return dir
+	}
+	if path.Clean(contentdir) != DefaultContentDir {
+		return ""
+	}
+	return defaultDir
+}
+
+// ProfileDirFor is where the sh profile snippet for contentdir is written,
+// empty when it isn't.
+func (s Settings) ProfileDirFor(contentdir string) string {
+	return profileDir(s.ProfileDir, ProfileDir, contentdir)
+}
+
+// FishDirFor is where the fish profile snippet for contentdir is written,
+// empty when it isn't.
+func (s Settings) FishDirFor(contentdir string) string {
+	return profileDir(s.FishDir, FishConfDir, contentdir)
+}
+
+// InWindow reports whether scheduled updates may run at t, which is always
+// the case without maintenance windows.
+func (s Settings) InWindow(t time.Time) bool {
+	if len(s.Windows) == 0 {
+		return true
+	}
+	for _, w := range s.Windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextWindow returns when the next maintenance window after t opens, zero
+// when there are no windows.
+func (s Settings) NextWindow(t time.Time) time.Time {
+	var next time.Time
+	t = t.Local()
+	for d := 0; d <= 7; d++ {
+		day := t.AddDate(0, 0, d)
+		for _, w := range s.Windows {
+			if !w.onDay(day.Weekday()) {
+				continue
+			}
+			start := time.Date(day.Year(), day.Month(), day.Day(), w.Start.Minutes/60, w.Start.Minutes%60, 0, 0, time.Local)
+			if start.After(t) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+	}
+	return next
+}
@@ -0,0 +1,66 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowContains(t *testing.T) {
+	clock := func(h int, m int) ClockTime {
+		return ClockTime{Minutes: h*60 + m}
+	}
+	// 2026-10-19 is a Monday
+	at := func(day int, h int, m int) time.Time {
+		return time.Date(2026, time.October, 19+day, h, m, 0, 0, time.Local)
+	}
+	const mon, tue, fri, sat, sun = 0, 1, 4, 5, -1
+	nightly := Window{Start: clock(2, 0), End: clock(4, 0)}
+	weekend := Window{Days: []Weekday{{time.Saturday}, {time.Sunday}}, Start: clock(22, 0), End: clock(6, 0)}
+	office := Window{Days: []Weekday{{time.Monday}}, Start: clock(9, 0), End: clock(17, 30)}
+	allDay := Window{Days: []Weekday{{time.Monday}}}
+
+	tests := []struct {
+		name   string
+		window Window
+		t      time.Time
+		want   bool
+	}{
+		{"nightly start", nightly, at(mon, 2, 0), true},
+		{"nightly inside", nightly, at(tue, 3, 59), true},
+		{"nightly end", nightly, at(mon, 4, 0), false},
+		{"nightly before", nightly, at(mon, 1, 59), false},
+		{"office inside", office, at(mon, 17, 29), true},
+		{"office end", office, at(mon, 17, 30), false},
+		{"office other day", office, at(tue, 12, 0), false},
+		{"overnight evening", weekend, at(sat, 23, 0), true},
+		{"overnight morning after", weekend, at(sun, 5, 59), true},
+		{"overnight spills into monday", weekend, at(mon, 5, 0), true},
+		{"overnight monday evening", weekend, at(mon, 23, 0), false},
+		{"overnight end", weekend, at(mon, 6, 0), false},
+		{"overnight friday evening", weekend, at(fri, 23, 0), false},
+		{"overnight saturday morning", weekend, at(sat, 5, 0), false},
+		{"whole day", allDay, at(mon, 0, 0), true},
+		{"whole day late", allDay, at(mon, 23, 59), true},
+		{"whole day other day", allDay, at(tue, 12, 0), false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.window.Contains(tt.t); got != tt.want {
+			t.Errorf("%s: Contains(%s) = %v, want %v", tt.name, tt.t.Format(time.RFC1123), got, tt.want)
+		}
+	}
+}
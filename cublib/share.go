@@ -0,0 +1,323 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// shareTree is the share or units directory of a generation being populated.
+// It remembers the bundle that provided each file so conflicting files of
+// other bundles are reported and skipped.
+type shareTree struct {
+	dir    string
+	owners map[string]string
+}
+
+func newShareTree(dir string) *shareTree {
+	return &shareTree{dir: dir, owners: make(map[string]string)}
+}
+
+// add creates rel in the tree with create unless another bundle already
+// provided it.
+func (t *shareTree) add(rel string, bundle string, create func(dst string) error) error {
+	if owner, ok := t.owners[rel]; ok {
+		log.Printf("WARNING: %s from %s conflicts with the one from %s, skipping it", rel, bundle, owner)
+		return nil
+	}
+	dst := path.Join(t.dir, rel)
+	if err := os.MkdirAll(path.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := create(dst); err != nil {
+		return err
+	}
+	t.owners[rel] = bundle
+	return nil
+}
+
+// walkShare calls fn with the path relative to the tree of every file under rel
+// in the usr/share directory of the content installed in installdir.
+func walkShare(installdir string, rel string, fn func(src string, rel string) error) error {
+	root := path.Join(installdir, "usr", "share", rel)
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == root {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		return fn(p, path.Join(rel, strings.TrimPrefix(p, root)))
+	})
+}
+
+// linkDir links every file under rel in the usr/share directory of the
+// content installed in installdir to the same place in the tree.
+func (t *shareTree) linkDir(installdir string, rel string, bundle string) error {
+	return walkShare(installdir, rel, func(src string, rel string) error {
+		return t.add(rel, bundle, func(dst string) error {
+			return os.Symlink(src, dst)
+		})
+	})
+}
+
+// splitExec splits an Exec= value into the program it runs, unquoted, and
+// the rest of the command line.
+func splitExec(value string) (string, string) {
+	if !strings.HasPrefix(value, "\"") {
+		if i := strings.IndexByte(value, ' '); i >= 0 {
+			return value[:i], value[i:]
+		}
+		return value, ""
+	}
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			unquote := strings.NewReplacer("\\\"", "\"", "\\`", "`", "\\$", "$", "\\\\", "\\")
+			return unquote.Replace(value[1:i]), value[i+1:]
+		}
+	}
+	return value, ""
+}
+
+// quoteExec quotes p as the program of an Exec= value when needed.
+func quoteExec(p string) string {
+	if !strings.ContainsAny(p, " \t\"'\\$`") {
+		return p
+	}
+	quote := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "`", "\\`", "$", "\\$")
+	return "\"" + quote.Replace(p) + "\""
+}
+
+// exportedCommand finds the command the bundle id exports for prog, either
+// the path of the application in the bundle or a command name.
+func exportedCommand(meta LauncherMetadata, id string, prog string) (string, bool) {
+	if la, ok := meta.Apps[prog]; ok && la.ID == id {
+		return prog, true
+	}
+	var names []string
+	for name, la := range meta.Apps {
+		if la.ID == id && (la.App == prog || (!path.IsAbs(prog) && path.Base(la.App) == prog)) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	sort.Strings(names)
+	return names[0], true
+}
+
+// exportedIcon maps an icon path in the bundle installed in installdir to the
+// exported copy, or to the bundle content for icons that aren't exported.
+func exportedIcon(contentdir string, installdir string, icon string) string {
+	for _, dir := range []string{"icons", "pixmaps"} {
+		prefix := path.Join("/usr/share", dir) + "/"
+		if strings.HasPrefix(icon, prefix) {
+			return path.Join(contentdir, "share", dir, strings.TrimPrefix(icon, prefix))
+		}
+	}
+	return path.Join(installdir, icon)
+}
+
+// rewriteDesktop points the programs and icons of a desktop entry of the
+// bundle id installed in installdir to the exported ones. Entries running a
+// program that isn't exported can't work and are refused.
+func rewriteDesktop(content []byte, contentdir string, installdir string, id string, meta LauncherMetadata) ([]byte, error) {
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+		switch key {
+		case "Exec", "TryExec":
+			prog, rest := splitExec(value)
+			if key == "TryExec" {
+				prog, rest = value, ""
+			}
+			name, ok := exportedCommand(meta, id, prog)
+			if !ok {
+				return nil, fmt.Errorf("%s runs %s which is not exported", key, prog)
+			}
+			lines[i] = key + "=" + quoteExec(path.Join(contentdir, "bin", name)) + rest
+		case "Icon":
+			if path.IsAbs(value) {
+				lines[i] = key + "=" + exportedIcon(contentdir, installdir, value)
+			}
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// exportDesktop adds the desktop entries, icons and MIME definitions of the
+// bundle e to the tree.
+func (t *shareTree) exportDesktop(contentdir string, e BundleExports, meta LauncherMetadata) error {
+	installdir := e.Bundle.ChrootDir(contentdir)
+	name := e.Bundle.Config.Bundle.Name
+	for _, dir := range []string{"icons", "pixmaps", "mime/packages"} {
+		if err := t.linkDir(installdir, dir, name); err != nil {
+			return err
+		}
+	}
+	return walkShare(installdir, "applications", func(src string, rel string) error {
+		if path.Ext(src) != ".desktop" {
+			return nil
+		}
+		content, err := ioutil.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if content, err = rewriteDesktop(content, contentdir, installdir, e.Bundle.ID, meta); err != nil {
+			log.Printf("WARNING: Not exporting %s from %s: %s", rel, name, err)
+			return nil
+		}
+		return t.add(rel, name, func(dst string) error {
+			return ioutil.WriteFile(dst, content, 0644)
+		})
+	})
+}
+
+// docDirs are the directories under usr/share with man pages and shell
+// completions, where they are exported to and how to tell which command a
+// file documents from its name.
+var docDirs = []struct {
+	src     string
+	dst     string
+	command func(name string) string
+}{
+	{"man", "man", manCommand},
+	{"bash-completion/completions", "bash-completion/completions", func(name string) string {
+		return strings.TrimSuffix(name, ".bash")
+	}},
+	{"zsh/site-functions", "zsh/site-functions", zshCommand},
+	{"zsh/vendor-completions", "zsh/site-functions", zshCommand},
+	{"fish/vendor_completions.d", "fish/vendor_completions.d", fishCommand},
+	{"fish/completions", "fish/vendor_completions.d", fishCommand},
+}
+
+// manCompression are the extensions of compressed man pages.
+var manCompression = []string{".gz", ".xz", ".bz2", ".zst", ".lzma"}
+
+// manCommand is the name a man page such as tool.1.gz documents.
+func manCommand(name string) string {
+	for _, ext := range manCompression {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+func zshCommand(name string) string {
+	return strings.TrimPrefix(name, "_")
+}
+
+func fishCommand(name string) string {
+	return strings.TrimSuffix(name, ".fish")
+}
+
+// hostShadow returns the host file with the same place under /usr/share as
+// the exported file rel, which is found first, empty when there is none.
+func hostShadow(rel string) string {
+	host := path.Join("/usr/share", rel)
+	candidates := []string{host}
+	if strings.HasPrefix(rel, "man/") {
+		base := host
+		for _, ext := range manCompression {
+			base = strings.TrimSuffix(base, ext)
+		}
+		candidates = []string{base}
+		for _, ext := range manCompression {
+			candidates = append(candidates, base+ext)
+		}
+	}
+	for _, c := range candidates {
+		if pathExists(c) {
+			return c
+		}
+	}
+	return ""
+}
+
+// exportDocs adds the man pages and shell completions of the bundle e to the
+// tree, only those of the commands it exports unless its docs policy is all.
+func (t *shareTree) exportDocs(contentdir string, e BundleExports, meta LauncherMetadata) error {
+	installdir := e.Bundle.ChrootDir(contentdir)
+	name := e.Bundle.Config.Bundle.Name
+	commands := make(map[string]bool)
+	for command, la := range meta.Apps {
+		if la.ID == e.Bundle.ID {
+			commands[command] = true
+			commands[path.Base(la.App)] = true
+		}
+	}
+	for _, d := range docDirs {
+		err := walkShare(installdir, d.src, func(src string, rel string) error {
+			if e.Docs != DocsAll && !commands[d.command(path.Base(src))] {
+				return nil
+			}
+			rel = path.Join(d.dst, strings.TrimPrefix(rel, d.src))
+			if host := hostShadow(rel); host != "" {
+				log.Printf("WARNING: %s from %s is shadowed by %s", rel, name, host)
+			}
+			return t.add(rel, name, func(dst string) error {
+				return os.Symlink(src, dst)
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateCaches rebuilds the caches desktop environments read for the exported
+// MIME definitions and desktop entries, when the tools are available.
+func (t *shareTree) updateCaches() {
+	for _, c := range []struct {
+		dir  string
+		tool string
+	}{{"mime", "update-mime-database"}, {"applications", "update-desktop-database"}} {
+		dir := path.Join(t.dir, c.dir)
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		if _, err := exec.LookPath(c.tool); err != nil {
+			continue
+		}
+		var out bytes.Buffer
+		cmd := exec.Command(c.tool, dir)
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		if err := cmd.Run(); err != nil {
+			log.Printf("WARNING: Unable to update %s: %s", dir, strings.TrimSpace(out.String()))
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"path"
+	"testing"
+)
+
+func TestRewriteDesktop(t *testing.T) {
+	meta := LauncherMetadata{Apps: map[string]LauncherApp{
+		"editor": {ID: "ID1", App: "/usr/bin/editor-qt"},
+		"tool":   {ID: "ID1", App: "/usr/bin/tool"},
+		"other":  {ID: "ID2", App: "/usr/bin/other"},
+	}}
+
+	tests := []struct {
+		name       string
+		contentdir string
+		content    string
+		want       string
+		fail       bool
+	}{
+		{
+			name:    "exec path",
+			content: "[Desktop Entry]\nName=Editor\nExec=/usr/bin/editor-qt %F\nTryExec=/usr/bin/editor-qt\n",
+			want:    "[Desktop Entry]\nName=Editor\nExec=/opt/3rd-party/bin/editor %F\nTryExec=/opt/3rd-party/bin/editor\n",
+		},
+		{
+			name:    "exec quoted",
+			content: "Exec=\"/usr/bin/editor-qt\" --new %U",
+			want:    "Exec=/opt/3rd-party/bin/editor --new %U",
+		},
+		{
+			name:    "exec name",
+			content: "Exec=editor-qt %F\nExec=tool",
+			want:    "Exec=/opt/3rd-party/bin/editor %F\nExec=/opt/3rd-party/bin/tool",
+		},
+		{
+			name:       "exec needs quoting",
+			contentdir: "/opt/3rd party",
+			content:    "Exec=tool %u",
+			want:       "Exec=\"/opt/3rd party/bin/tool\" %u",
+		},
+		{
+			name:    "icons",
+			content: "Icon=/usr/share/icons/hicolor/48x48/apps/editor.png\nIcon=/usr/share/pixmaps/editor.xpm\nIcon=/opt/vendor/editor.png\nIcon=editor",
+			want:    "Icon=/opt/3rd-party/share/icons/hicolor/48x48/apps/editor.png\nIcon=/opt/3rd-party/share/pixmaps/editor.xpm\nIcon=/opt/3rd-party/chroot/ID1/opt/vendor/editor.png\nIcon=editor",
+		},
+		{
+			name:    "other bundle",
+			content: "Exec=/usr/bin/other",
+			fail:    true,
+		},
+		{
+			name:    "not exported",
+			content: "Exec=/usr/bin/editor-gtk %F",
+			fail:    true,
+		},
+		{
+			name:    "tryexec not exported",
+			content: "Exec=/usr/bin/tool\nTryExec=/usr/bin/missing",
+			fail:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		contentdir := tt.contentdir
+		if contentdir == "" {
+			contentdir = "/opt/3rd-party"
+		}
+		installdir := path.Join(contentdir, "chroot", "ID1")
+		got, err := rewriteDesktop([]byte(tt.content), contentdir, installdir, "ID1", meta)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		} else if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
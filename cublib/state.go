@@ -0,0 +1,240 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// StateVersion is the version of the state database layout written by this
+// code. Databases with a newer version are refused rather than rewritten.
+const StateVersion = 1
+
+// Scheduled updates of a bundle that failed to update are retried after
+// BackoffBase, doubling with every further consecutive failure up to
+// BackoffMax.
+const (
+	BackoffBase = time.Hour
+	BackoffMax  = 24 * time.Hour
+)
+
+// Results recorded for the last update attempt of a bundle.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// BundleState is everything recorded about an installed bundle.
+type BundleState struct {
+	ID               string
+	URL              string
+	Name             string
+	InstalledVersion string
+	Installed        time.Time
+	Updated          time.Time
+	LastAttempt      time.Time
+	LastResult       string
+	LastError        string
+	LastSuccess      time.Time
+	LastFailure      time.Time
+	Failures         int // consecutive failed update attempts
+	LatestVersion    string
+	LastChecked      time.Time
+	DeferredUntil    time.Time
+	CertFingerprint  string
+	Hold             bool
+	Includes         []string
+}
+
+// State is the 3rd-party state database kept in the statedir.
+type State struct {
+	Version      int
+	Alternatives map[string]string // command name to the ID of the bundle chosen to export it
+	Bundles      []*BundleState    `toml:"bundle"`
+}
+
+func statePath(statedir string) string {
+	return path.Join(statedir, "3rd-party", "state.toml")
+}
+
+// LoadState reads the state database, a missing database is empty.
+func LoadState(statedir string) (*State, error) {
+	state := &State{Version: StateVersion}
+	if _, err := toml.DecodeFile(statePath(statedir), state); err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, fmt.Errorf("Unable to read 3rd-party state (%s): %s", statePath(statedir), err)
+	}
+	if state.Version > StateVersion {
+		return nil, fmt.Errorf("3rd-party state (%s) version %d is newer than supported version %d", statePath(statedir), state.Version, StateVersion)
+	}
+	return state, nil
+}
+
+// Save replaces the state database with s. The new database is written next
+// to the old one and renamed over it so readers see either the old or the new
+// state, never a partial one.
+func (s *State) Save(statedir string) error {
+	dir := path.Dir(statePath(statedir))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	s.Version = StateVersion
+	tmpPath := statePath(statedir) + ".new"
+	out, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err = toml.NewEncoder(out).Encode(s); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, statePath(statedir)); err != nil {
+		return err
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Bundle returns the record for id or nil when there is none.
+func (s *State) Bundle(id string) *BundleState {
+	for _, b := range s.Bundles {
+		if b.ID == id {
+			return b
+		}
+	}
+	return nil
+}
+
+// Remove drops the record for id and the alternatives chosen for it.
+func (s *State) Remove(id string) {
+	for command, chosen := range s.Alternatives {
+		if chosen == id {
+			delete(s.Alternatives, command)
+		}
+	}
+	for i, b := range s.Bundles {
+		if b.ID == id {
+			s.Bundles = append(s.Bundles[:i], s.Bundles[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetAlternative chooses the bundle id to export command when several
+// bundles do, an empty id drops the choice.
+func (s *State) SetAlternative(command string, id string) {
+	if id == "" {
+		delete(s.Alternatives, command)
+		return
+	}
+	if s.Alternatives == nil {
+		s.Alternatives = make(map[string]string)
+	}
+	s.Alternatives[command] = id
+}
+
+// RecordAttempt records the outcome of an update attempt made at t.
+func (bs *BundleState) RecordAttempt(t time.Time, err error) {
+	bs.LastAttempt = t
+	if err != nil {
+		bs.LastResult = ResultFailure
+		bs.LastError = err.Error()
+		bs.LastFailure = t
+		bs.Failures++
+		return
+	}
+	bs.LastResult = ResultSuccess
+	bs.LastError = ""
+	bs.LastSuccess = t
+	bs.Failures = 0
+}
+
+// NextAttempt is the earliest time a scheduled update should try the bundle
+// again, zero when it isn't failing.
+func (bs *BundleState) NextAttempt() time.Time {
+	if bs.Failures == 0 || bs.LastFailure.IsZero() {
+		return time.Time{}
+	}
+	backoff := BackoffBase
+	for i := 1; i < bs.Failures && backoff < BackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > BackoffMax {
+		backoff = BackoffMax
+	}
+	return bs.LastFailure.Add(backoff)
+}
+
+// RecordLatest records the latest version published by the bundle repo as
+// found at t.
+func (bs *BundleState) RecordLatest(t time.Time, version string) {
+	bs.LatestVersion = version
+	bs.LastChecked = t
+}
+
+// AddIncludes records host bundles installed on behalf of the bundle.
+func (bs *BundleState) AddIncludes(includes ...string) {
+	for _, include := range includes {
+		found := false
+		for _, i := range bs.Includes {
+			if i == include {
+				found = true
+				break
+			}
+		}
+		if !found {
+			bs.Includes = append(bs.Includes, include)
+		}
+	}
+}
+
+// Track returns the record for an installed bundle, creating one from what is
+// on disk for bundles installed before the state database existed.
+func (s *State) Track(b Bundle, statedir string, contentdir string) *BundleState {
+	if bs := s.Bundle(b.ID); bs != nil {
+		return bs
+	}
+	pchrootdir := b.ChrootDir(contentdir)
+	bs := &BundleState{ID: b.ID, URL: b.Config.Bundle.URL, Name: b.Config.Bundle.Name}
+	bs.InstalledVersion, _ = GetInstalledVersion(pchrootdir)
+	if fi, err := os.Stat(b.ConfigPath(contentdir)); err == nil {
+		bs.Installed = fi.ModTime()
+	}
+	if cert, err := GetCertInfo(GetBundleCertPath(pchrootdir)); err == nil {
+		bs.CertFingerprint = cert.Fingerprint
+	}
+	bs.Updated = GetUpdatedTime(pchrootdir)
+	bs.LastSuccess = bs.Updated
+	s.Bundles = append(s.Bundles, bs)
+	return bs
+}
@@ -0,0 +1,323 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// Locations of systemd units, in the bundle content and on the host where
+// units are linked and enabled.
+const (
+	bundleUnitDir = "usr/lib/systemd/system"
+	SystemUnitDir = "/etc/systemd/system"
+	hostUnitDir   = "/usr/lib/systemd/system"
+)
+
+// unitTypes are the unit types a bundle may export.
+var unitTypes = []string{".service", ".socket", ".timer", ".path", ".target"}
+
+// unitHeader starts every exported unit, naming the bundle it came from.
+const unitHeader = "# Exported by 3rd-party-post from bundle %s (%s)\n"
+
+// unitPath is the PATH systemd gives services, which the bundle's programs
+// are found ahead of.
+const unitPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin"
+
+func validateUnit(unit string) error {
+	if strings.Contains(unit, "/") || strings.HasPrefix(unit, ".") {
+		return fmt.Errorf("invalid unit name %s", unit)
+	}
+	for _, t := range unitTypes {
+		if strings.HasSuffix(unit, t) && len(unit) > len(t) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid unit %s, expected one of the types %s", unit, strings.Join(unitTypes, " "))
+}
+
+// UnitDir is where the units of the bundles are exported.
+func UnitDir(contentdir string) string {
+	return path.Join(contentdir, "units")
+}
+
+// rewriteExec points the program an Exec setting runs at the bundle content
+// in installdir when it is installed there.
+func rewriteExec(value string, installdir string) string {
+	rest := strings.TrimLeft(value, "@-:+!|")
+	prefix := value[:len(value)-len(rest)]
+	prog, args := rest, ""
+	if strings.HasPrefix(rest, "\"") {
+		if end := strings.Index(rest[1:], "\""); end >= 0 {
+			prog, args = rest[1:end+1], rest[end+2:]
+		}
+	} else if i := strings.IndexAny(rest, " \t"); i >= 0 {
+		prog, args = rest[:i], rest[i:]
+	}
+	if !path.IsAbs(prog) {
+		return value
+	}
+	if _, err := os.Lstat(path.Join(installdir, prog)); err != nil {
+		return value
+	}
+	prog = path.Join(installdir, prog)
+	if strings.ContainsAny(prog, " \t") {
+		prog = "\"" + prog + "\""
+	}
+	return prefix + prog + args
+}
+
+// unitEnvironment is the environment services run with to find the programs
+// and libraries of the bundle content in installdir, as applications run by
+// the launcher do. Settings the unit makes itself come later and win.
+func unitEnvironment(installdir string) string {
+	escape := strings.NewReplacer(`%`, `%%`, `\`, `\\`, `"`, `\"`)
+	return fmt.Sprintf("Environment=\"PATH=%s:%s\"\nEnvironment=\"LD_LIBRARY_PATH=%s\"\n",
+		escape.Replace(path.Join(installdir, "usr", "bin")), unitPath, escape.Replace(path.Join(installdir, "usr", "lib64")))
+}
+
+// rewriteUnit returns the unit content with the programs its Exec settings
+// run pointed at the bundle content in installdir and its service set up to
+// use that content.
+func rewriteUnit(content []byte, installdir string) []byte {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "[Service]" {
+			out.WriteString(line + "\n" + unitEnvironment(installdir))
+			continue
+		}
+		if eq := strings.Index(trimmed, "="); strings.HasPrefix(trimmed, "Exec") && eq > 0 {
+			key := strings.TrimSpace(trimmed[:eq])
+			value := strings.TrimSpace(trimmed[eq+1:])
+			if value != "" {
+				line = key + "=" + rewriteExec(value, installdir)
+			}
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.Bytes()
+}
+
+// hostUnit returns the host unit unit conflicts with, empty when there is
+// none. Units linked from the exported ones don't count.
+func hostUnit(contentdir string, unit string) string {
+	for _, dir := range []string{SystemUnitDir, hostUnitDir} {
+		p := path.Join(dir, unit)
+		if _, err := os.Lstat(p); err != nil {
+			continue
+		}
+		if target, err := os.Readlink(p); err == nil && strings.HasPrefix(target, UnitDir(contentdir)+"/") {
+			continue
+		}
+		return p
+	}
+	return ""
+}
+
+// exportUnits adds the units the bundle e declares to the tree.
+func (t *shareTree) exportUnits(contentdir string, e BundleExports) error {
+	installdir := e.Bundle.ChrootDir(contentdir)
+	name := e.Bundle.Config.Bundle.Name
+	for _, unit := range e.Bundle.Config.Bundle.Units {
+		if err := validateUnit(unit); err != nil {
+			log.Printf("WARNING: Ignoring part of the %s config: %s", name, err)
+			continue
+		}
+		content, err := ioutil.ReadFile(path.Join(installdir, bundleUnitDir, unit))
+		if os.IsNotExist(err) {
+			log.Printf("WARNING: Unit %s of %s is not installed in %s", unit, name, bundleUnitDir)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if host := hostUnit(contentdir, unit); host != "" {
+			log.Printf("WARNING: Unit %s from %s is shadowed by %s", unit, name, host)
+		}
+		content = append([]byte(fmt.Sprintf(unitHeader, name, e.Bundle.ID)), rewriteUnit(content, installdir)...)
+		err = t.add(unit, name, func(dst string) error {
+			return ioutil.WriteFile(dst, content, 0644)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnitOwner returns the ID of the bundle the exported unit came from, empty
+// when the unit isn't exported.
+func UnitOwner(contentdir string, unit string) string {
+	f, err := os.Open(path.Join(UnitDir(contentdir), unit))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	if !strings.HasPrefix(line, "# Exported by 3rd-party-post from bundle ") {
+		return ""
+	}
+	line = strings.TrimSpace(line)
+	open := strings.LastIndex(line, "(")
+	if open < 0 || !strings.HasSuffix(line, ")") {
+		return ""
+	}
+	return line[open+1 : len(line)-1]
+}
+
+// exportedUnits maps every exported unit to its content.
+func exportedUnits(contentdir string) map[string]string {
+	units := make(map[string]string)
+	files, err := ioutil.ReadDir(UnitDir(contentdir))
+	if err != nil {
+		return units
+	}
+	for _, f := range files {
+		if content, err := ioutil.ReadFile(path.Join(UnitDir(contentdir), f.Name())); err == nil {
+			units[f.Name()] = string(content)
+		}
+	}
+	return units
+}
+
+// systemctl runs systemctl with args when systemd manages the system,
+// returning its output.
+func systemctl(args ...string) (string, error) {
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		return "", fmt.Errorf("systemd is not running")
+	}
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return "", err
+	}
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+// UnitStatus returns whether the unit is enabled and active according to
+// systemctl, "unknown" when it can't tell.
+func UnitStatus(unit string) (string, string) {
+	status := []string{"unknown", "unknown"}
+	for i, query := range []string{"is-enabled", "is-active"} {
+		// Both exit with an error for disabled and inactive units
+		if out, _ := systemctl(query, unit); out != "" && !strings.Contains(out, "\n") {
+			status[i] = out
+		}
+	}
+	return status[0], status[1]
+}
+
+// unitLinks returns the links in the unit directory of the host, and the
+// directories units are enabled in there, that link or enable an exported
+// unit.
+func unitLinks(contentdir string) []string {
+	dirs := []string{SystemUnitDir}
+	if entries, err := ioutil.ReadDir(SystemUnitDir); err == nil {
+		for _, e := range entries {
+			if e.IsDir() {
+				dirs = append(dirs, path.Join(SystemUnitDir, e.Name()))
+			}
+		}
+	}
+	prefix := UnitDir(contentdir) + "/"
+	exported := func(link string) bool {
+		target, err := os.Readlink(link)
+		return err == nil && strings.HasPrefix(target, prefix)
+	}
+	links := []string{}
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			link := path.Join(dir, e.Name())
+			linked := path.Join(SystemUnitDir, e.Name())
+			// Units enabled after linking them are enabled through the link
+			if exported(link) || (link != linked && exported(linked)) {
+				links = append(links, link)
+			}
+		}
+	}
+	return links
+}
+
+// pruneUnitLinks removes the links enabling or linking the exported units
+// that are gone from the unit directory of the host.
+func pruneUnitLinks(contentdir string) {
+	for _, link := range unitLinks(contentdir) {
+		if _, err := os.Stat(link); !os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Remove(link); err != nil {
+			log.Printf("WARNING: Unable to remove %s: %s", link, err)
+			continue
+		}
+		log.Printf("Removed %s, the unit is no longer exported", link)
+	}
+}
+
+// updateUnits cleans up after the exported units changed from before and
+// has systemd reload them.
+func updateUnits(contentdir string, before map[string]string) {
+	if reflect.DeepEqual(before, exportedUnits(contentdir)) {
+		return
+	}
+	pruneUnitLinks(contentdir)
+	if out, err := systemctl("daemon-reload"); err != nil && out != "" {
+		log.Printf("WARNING: Unable to reload systemd units: %s", out)
+	}
+}
+
+// ReleaseUnits stops and disables the exported units of the bundle id ahead
+// of its removal and removes the links to them from the unit directory of
+// the host, which systemctl can't when systemd isn't running.
+func ReleaseUnits(contentdir string, id string) {
+	owned := make(map[string]bool)
+	for unit := range exportedUnits(contentdir) {
+		if UnitOwner(contentdir, unit) != id {
+			continue
+		}
+		owned[unit] = true
+		if out, err := systemctl("disable", "--now", unit); err != nil && out != "" {
+			log.Printf("WARNING: Unable to stop and disable %s: %s", unit, out)
+		}
+	}
+	if len(owned) == 0 {
+		return
+	}
+	for _, link := range unitLinks(contentdir) {
+		if !owned[path.Base(link)] {
+			continue
+		}
+		if err := os.Remove(link); err != nil {
+			log.Printf("WARNING: Unable to remove %s: %s", link, err)
+		}
+	}
+	if out, err := systemctl("daemon-reload"); err != nil && out != "" {
+		log.Printf("WARNING: Unable to reload systemd units: %s", out)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cublib
+
+import (
+	"path"
+	"testing"
+)
+
+func TestRewriteExec(t *testing.T) {
+	installdir := path.Join(t.TempDir(), "ID1")
+	mkfile(t, path.Join(installdir, "usr/bin/vendord"), "#!/bin/sh\n", 0755)
+	mkfile(t, path.Join(installdir, "usr/lib/vendor app/helper"), "#!/bin/sh\n", 0755)
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"/usr/bin/vendord", installdir + "/usr/bin/vendord"},
+		{"/usr/bin/vendord --daemon -v", installdir + "/usr/bin/vendord --daemon -v"},
+		{"/usr/bin/vendord\t--daemon", installdir + "/usr/bin/vendord\t--daemon"},
+		{"-/usr/bin/vendord --check", "-" + installdir + "/usr/bin/vendord --check"},
+		{"@-/usr/bin/vendord vendord", "@-" + installdir + "/usr/bin/vendord vendord"},
+		{"!!/usr/bin/vendord", "!!" + installdir + "/usr/bin/vendord"},
+		{`"/usr/lib/vendor app/helper" x`, `"` + installdir + `/usr/lib/vendor app/helper" x`},
+		// Programs the bundle doesn't install are the host's
+		{"/bin/kill $MAINPID", "/bin/kill $MAINPID"},
+		{"/usr/bin/missing --flag", "/usr/bin/missing --flag"},
+		// Relative programs are looked up in the PATH by systemd
+		{"vendord --daemon", "vendord --daemon"},
+		{`"/usr/lib/vendor app/missing"`, `"/usr/lib/vendor app/missing"`},
+	}
+
+	for _, tt := range tests {
+		if got := rewriteExec(tt.value, installdir); got != tt.want {
+			t.Errorf("rewriteExec(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteUnit(t *testing.T) {
+	installdir := path.Join(t.TempDir(), "ID1")
+	mkfile(t, path.Join(installdir, "usr/bin/vendord"), "#!/bin/sh\n", 0755)
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "service",
+			content: "[Unit]\nDescription=Vendor\n\n[Service]\nEnvironment=PATH=/opt\nExecStart = /usr/bin/vendord\nExecReload=\n",
+			want: "[Unit]\nDescription=Vendor\n\n[Service]\n" +
+				"Environment=\"PATH=" + installdir + "/usr/bin:" + unitPath + "\"\n" +
+				"Environment=\"LD_LIBRARY_PATH=" + installdir + "/usr/lib64\"\n" +
+				"Environment=PATH=/opt\nExecStart=" + installdir + "/usr/bin/vendord\nExecReload=\n",
+		},
+		{
+			name:    "timer",
+			content: "[Timer]\nOnCalendar=daily\n",
+			want:    "[Timer]\nOnCalendar=daily\n",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := string(rewriteUnit([]byte(tt.content), installdir)); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
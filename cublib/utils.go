@@ -17,6 +17,7 @@ package cublib
 import (
 	"bytes"
 	"encoding/base64"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -25,8 +26,10 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"strings"
 	"syscall"
+	"unsafe"
 )
 
 var lockfd = -1
@@ -109,6 +112,29 @@ func GetLock(statedir string) {
 	lockfd = fd
 }
 
+// GetSharedLock takes the statedir lock for reading, it fails rather than
+// waiting while an operation changing the state holds it. Nothing needs to be
+// locked when no such operation ever ran.
+func GetSharedLock(statedir string) error {
+	lockfile := path.Join(statedir, "3rd-party.lock")
+	flock := syscall.Flock_t{
+		Type: syscall.F_RDLCK,
+		Start: 0, Len: 0, Whence: 0, Pid: int32(syscall.Getpid()),
+	}
+	fd, err := syscall.Open(lockfile, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err == syscall.ENOENT {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Lockfile (%s) open failed: %s", lockfile, err)
+	}
+	if err := syscall.FcntlFlock(uintptr(fd), syscall.F_SETLK, &flock); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("Unable to set flock on %s: %s", lockfile, err)
+	}
+	lockfd = fd
+	return nil
+}
+
 func ReleaseLock(statedir string) {
 	if lockfd >= 0 {
 		syscall.Close(lockfd)
@@ -152,3 +178,31 @@ func GetCert(statedir string, uri string) (string, error) {
 func GetEncodedBundleName(url string, name string) string {
 	return base64.StdEncoding.EncodeToString([]byte(url + name))
 }
+
+// DirSize returns the apparent size of everything under root without following symlinks.
+func DirSize(root string) (int64, error) {
+	var size int64
+	err := filepath.Walk(root, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// IsHostBundleInstalled reports whether swupd has the bundle name installed on the host.
+func IsHostBundleInstalled(name string) bool {
+	_, err := os.Stat(path.Join("/usr/share/clear/bundles", name))
+	return err == nil
+}
+
+// IsTerminal reports whether fd refers to a terminal.
+func IsTerminal(fd uintptr) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
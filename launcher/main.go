@@ -0,0 +1,72 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"github.com/clearlinux/clr-user-bundles/cublib"
+)
+
+// Exit statuses used by shells for commands that can't be found or run.
+const (
+	exitNotFound   = 127
+	exitCannotExec = 126
+)
+
+// The launcher is installed once in the exported bin directory with every
+// exported application linking to it, it runs the application matching the
+// name it was invoked by.
+func main() {
+	name := filepath.Base(os.Args[0])
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Unable to find launcher: %s\n", name, err)
+		os.Exit(exitNotFound)
+	}
+	meta, err := cublib.ReadLauncherMetadata(filepath.Dir(exe))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Unable to read launcher metadata: %s\n", name, err)
+		os.Exit(exitNotFound)
+	}
+	app, ok := meta.Apps[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s: Not an exported 3rd-party application\n", name)
+		os.Exit(exitNotFound)
+	}
+	// The application sees the path it is installed at as argv[0], as it
+	// would when run directly
+	argv := append(append([]string{app.Target}, app.Args...), os.Args[1:]...)
+	if app.WorkDir != "" {
+		if err = os.Chdir(app.WorkDir); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: Unable to change to working directory: %s\n", name, err)
+			os.Exit(exitCannotExec)
+		}
+	}
+	env := app.Environ(os.Environ())
+	err = syscall.Exec(app.Target, argv, env)
+	if err == syscall.ENOEXEC {
+		// Scripts without an interpreter line are run by the shell, like
+		// execvp and the runner scripts this replaces do
+		err = syscall.Exec("/bin/sh", append([]string{"/bin/sh"}, argv...), env)
+	}
+	fmt.Fprintf(os.Stderr, "%s: Unable to run %s: %s\n", name, app.Target, err)
+	if os.IsNotExist(err) {
+		os.Exit(exitNotFound)
+	}
+	os.Exit(exitCannotExec)
+}
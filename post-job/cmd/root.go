@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"log"
 	"github.com/spf13/cobra"
+	"github.com/clearlinux/clr-user-bundles/cublib"
 	"github.com/clearlinux/clr-user-bundles/post-job/operations"
 )
 
@@ -33,12 +34,13 @@ var rootCmd = &cobra.Command{
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		operations.ProcessContent(StateDirectory, ContentDirectory)
+		operations.ProcessContent(StateDirectory, ContentDirectory, SettingsFile)
 	},
 }
 
 var StateDirectory string
 var ContentDirectory string
+var SettingsFile string
 
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
@@ -48,5 +50,6 @@ func Execute() {
 
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&StateDirectory, "statedir", "s", "/var/lib/swupd", "swupd state directory")
-	rootCmd.PersistentFlags().StringVarP(&ContentDirectory, "contentdir", "c", "/opt/3rd-party", "3rd-party content directory")
+	rootCmd.PersistentFlags().StringVarP(&ContentDirectory, "contentdir", "c", cublib.DefaultContentDir, "3rd-party content directory")
+	rootCmd.PersistentFlags().StringVar(&SettingsFile, "config", "", "swupd-3rd-party settings file (default "+cublib.SettingsPath+")")
 }
@@ -19,11 +19,16 @@ import (
 	"github.com/clearlinux/clr-user-bundles/cublib"
 )
 
-func ProcessContent(statedir string, contentdir string) {
+func ProcessContent(statedir string, contentdir string, settingsPath string) {
 	// GetLock causes program exit on failure to acquire lockfile
 	cublib.GetLock(statedir)
 	defer cublib.ReleaseLock(statedir)
-	err := cublib.PostProcess(statedir, contentdir)
+	entry := cublib.NewHistoryEntry(cublib.OpPost)
+	err := cublib.PostProcess(statedir, contentdir, settingsPath)
+	entry.Finish(err)
+	if herr := cublib.AppendHistory(statedir, entry); herr != nil {
+		log.Printf("WARNING: Unable to record post-process in 3rd-party history: %s", herr)
+	}
 	if err != nil {
 		log.Fatalf("%s", err)
 	}
@@ -33,11 +33,16 @@ var addCmd = &cobra.Command{
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		operations.Add(args[0], StateDirectory, ContentDirectory, skipPost)
+		operations.Add(args[0], StateDirectory, ContentDirectory, SettingsFile, skipPost, addDryRun, addYes, OutputFormat)
 	},
 }
 
+var addDryRun bool
+var addYes bool
+
 func init() {
 	addCmd.PersistentFlags().BoolVarP(&skipPost, "skip-post", "p", false, "Skip running post-3rd-party hooks")
+	addCmd.Flags().BoolVarP(&addDryRun, "dry-run", "n", false, "Show what would be added without changing anything")
+	addCmd.Flags().BoolVarP(&addYes, "yes", "y", false, "Add without asking for confirmation")
 	rootCmd.AddCommand(addCmd)
 }
@@ -0,0 +1,58 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/clearlinux/clr-user-bundles/swupd-wrapper/operations"
+)
+
+var alternativesCmd = &cobra.Command{
+	Use: "alternatives [COMMAND] [BUNDLE]",
+	Short: "Show or choose which 3rd party bundle exports a command",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 2 {
+			return fmt.Errorf("Invalid arguments")
+		}
+		if alternativesReset && len(args) != 1 {
+			return fmt.Errorf("--reset takes a single COMMAND")
+		}
+		if cmd.PersistentFlags().Changed("skip-post") {
+			skipPost = true
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		switch {
+		case alternativesReset:
+			operations.SetAlternative(StateDirectory, ContentDirectory, SettingsFile, args[0], "", skipPost)
+		case len(args) == 2:
+			operations.SetAlternative(StateDirectory, ContentDirectory, SettingsFile, args[0], args[1], skipPost)
+		case len(args) == 1:
+			operations.Alternatives(StateDirectory, ContentDirectory, SettingsFile, args[0], OutputFormat)
+		default:
+			operations.Alternatives(StateDirectory, ContentDirectory, SettingsFile, "", OutputFormat)
+		}
+	},
+}
+
+var alternativesReset bool
+
+func init() {
+	alternativesCmd.PersistentFlags().BoolVarP(&skipPost, "skip-post", "p", false, "Skip running post-3rd-party hooks")
+	alternativesCmd.Flags().BoolVarP(&alternativesReset, "reset", "r", false, "Drop the choice for COMMAND and use the priority order")
+	rootCmd.AddCommand(alternativesCmd)
+}
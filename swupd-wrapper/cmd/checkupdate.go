@@ -0,0 +1,32 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/clearlinux/clr-user-bundles/swupd-wrapper/operations"
+)
+
+var checkUpdateCmd = &cobra.Command{
+	Use: "check-update",
+	Short: "Check for 3rd party bundle updates",
+	Run: func(cmd *cobra.Command, args []string) {
+		operations.CheckUpdate(StateDirectory, ContentDirectory, SettingsFile, OutputFormat)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checkUpdateCmd)
+}
@@ -0,0 +1,39 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/clearlinux/clr-user-bundles/swupd-wrapper/operations"
+)
+
+var diffCmd = &cobra.Command{
+	Use: "diff [BUNDLE]",
+	Short: "Show what updating a 3rd party bundle would change",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("Invalid arguments")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		operations.Diff(StateDirectory, ContentDirectory, args[0], OutputFormat)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+}
@@ -0,0 +1,54 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/clearlinux/clr-user-bundles/swupd-wrapper/operations"
+)
+
+var filesCmd = &cobra.Command{
+	Use: "files [BUNDLE]",
+	Short: "List files installed by a 3rd party bundle",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("Invalid arguments")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		operations.Files(StateDirectory, ContentDirectory, args[0], OutputFormat)
+	},
+}
+
+var ownsCmd = &cobra.Command{
+	Use: "owns [PATH]",
+	Short: "Show which 3rd party bundle installed a path",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("Invalid arguments")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		operations.Owns(StateDirectory, ContentDirectory, args[0], OutputFormat)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(filesCmd)
+	rootCmd.AddCommand(ownsCmd)
+}
@@ -23,7 +23,7 @@ var listCmd = &cobra.Command{
 	Use: "list",
 	Short: "list 3rd party bundle metadata",
 	Run: func(cmd *cobra.Command, args []string) {
-		operations.List(StateDirectory, ContentDirectory)
+		operations.List(StateDirectory, ContentDirectory, OutputFormat)
 	},
 }
 
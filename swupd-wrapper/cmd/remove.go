@@ -34,11 +34,18 @@ var removeCmd = &cobra.Command{
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		operations.Remove(StateDirectory, ContentDirectory, args[0], args[1], skipPost, true)
+		if removeDryRun {
+			operations.RemoveDryRun(StateDirectory, ContentDirectory, args[0], args[1], OutputFormat)
+			return
+		}
+		operations.Remove(StateDirectory, ContentDirectory, SettingsFile, args[0], args[1], skipPost, true)
 	},
 }
 
+var removeDryRun bool
+
 func init() {
 	removeCmd.PersistentFlags().BoolVarP(&skipPost, "skip-post", "p", false, "Skip running post-3rd-party hooks")
+	removeCmd.Flags().BoolVarP(&removeDryRun, "dry-run", "n", false, "Show what would be removed without changing anything")
 	rootCmd.AddCommand(removeCmd)
 }
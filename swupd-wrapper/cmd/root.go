@@ -18,6 +18,8 @@ import (
 	"fmt"
 	"os"
 	"github.com/spf13/cobra"
+	"github.com/clearlinux/clr-user-bundles/cublib"
+	"github.com/clearlinux/clr-user-bundles/swupd-wrapper/operations"
 )
 
 var rootCmd = &cobra.Command{
@@ -32,6 +34,9 @@ var rootCmd = &cobra.Command{
 		}
 		return nil
 	},
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return operations.ValidOutput(OutputFormat)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		cmd.Print(cmd.UsageString())
 	},
@@ -39,16 +44,20 @@ var rootCmd = &cobra.Command{
 
 var StateDirectory string
 var ContentDirectory string
+var OutputFormat string
+var SettingsFile string
 var skipPost bool
 
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&StateDirectory, "statedir", "s", "/var/lib/swupd", "swupd state directory")
-	rootCmd.PersistentFlags().StringVarP(&ContentDirectory, "contentdir", "c", "/opt/3rd-party", "3rd-party content directory")
+	rootCmd.PersistentFlags().StringVarP(&ContentDirectory, "contentdir", "c", cublib.DefaultContentDir, "3rd-party content directory")
+	rootCmd.PersistentFlags().StringVar(&SettingsFile, "config", "", "swupd-3rd-party settings file (default "+cublib.SettingsPath+")")
+	rootCmd.PersistentFlags().StringVarP(&OutputFormat, "output", "o", operations.OutputTable, "Output format (table, json or toml)")
 }
@@ -0,0 +1,48 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+	"github.com/spf13/cobra"
+	"github.com/clearlinux/clr-user-bundles/swupd-wrapper/operations"
+)
+
+var statusCmd = &cobra.Command{
+	Use: "status",
+	Short: "Show the health of automatic 3rd party updates",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 {
+			return fmt.Errorf("Invalid arguments")
+		}
+		if statusMaxFailures < 1 {
+			return fmt.Errorf("max-failures must be at least 1")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		operations.Status(StateDirectory, ContentDirectory, statusMaxFailures, statusMaxAge, OutputFormat)
+	},
+}
+
+var statusMaxFailures int
+var statusMaxAge time.Duration
+
+func init() {
+	statusCmd.Flags().IntVar(&statusMaxFailures, "max-failures", 3, "Consecutive update failures of a bundle that are critical")
+	statusCmd.Flags().DurationVar(&statusMaxAge, "max-age", 7*24*time.Hour, "Time since the last successful update of a bundle that is critical, 0 to disable")
+	rootCmd.AddCommand(statusCmd)
+}
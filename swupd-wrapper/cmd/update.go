@@ -30,11 +30,20 @@ var updateCmd = &cobra.Command{
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		operations.Update(StateDirectory, ContentDirectory, skipPost)
+		if updateDryRun {
+			operations.UpdateDryRun(StateDirectory, ContentDirectory, SettingsFile, OutputFormat)
+			return
+		}
+		operations.Update(StateDirectory, ContentDirectory, SettingsFile, skipPost, updateScheduled)
 	},
 }
 
+var updateDryRun bool
+var updateScheduled bool
+
 func init() {
 	updateCmd.PersistentFlags().BoolVarP(&skipPost, "skip-post", "p", false, "Skip running post-3rd-party hooks")
+	updateCmd.Flags().BoolVarP(&updateDryRun, "dry-run", "n", false, "Show what would be updated without changing anything")
+	updateCmd.Flags().BoolVar(&updateScheduled, "scheduled", false, "Back off from bundles that keep failing to update")
 	rootCmd.AddCommand(updateCmd)
 }
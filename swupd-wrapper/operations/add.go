@@ -15,35 +15,261 @@
 package operations
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"path"
 	"os"
 	"os/exec"
+	"strings"
+	"time"
 	"github.com/clearlinux/clr-user-bundles/cublib"
 )
 
-func Add(uri string, statedir string, contentdir string, skipPost bool) {
-	// GetLock causes program exit on failure to acquire lockfile
-	cublib.GetLock(statedir)
-	defer cublib.ReleaseLock(statedir)
-	format, err := cublib.GetFormat()
+type AddPlan struct {
+	URI          string   `json:"uri" toml:"uri"`
+	ID           string   `json:"id" toml:"id"`
+	Name         string   `json:"name" toml:"name"`
+	Description  string   `json:"description" toml:"description"`
+	URL          string   `json:"url" toml:"url"`
+	Version      string   `json:"version" toml:"version"`
+	Signer       string   `json:"signer" toml:"signer"`
+	Fingerprint  string   `json:"fingerprint" toml:"fingerprint"`
+	Trusted      bool     `json:"trusted" toml:"trusted"`
+	TrustError   string   `json:"trust_error" toml:"trust_error"`
+	HostBundles  []string `json:"host_bundles" toml:"host_bundles"`
+	Applications []string `json:"applications" toml:"applications"`
+	// ContentSize is the installed size from the bundle manifest, 0 when
+	// the manifest couldn't be loaded.
+	ContentSize  int64    `json:"content_size" toml:"content_size"`
+	Installed    bool     `json:"installed" toml:"installed"`
+
+	config cublib.TomlConfig
+	cert   []byte
+}
+
+// verifyCert checks certPath against the system trust store.
+func verifyCert(certPath string) error {
+	out := bytes.Buffer{}
+	cmd := exec.Command("openssl", "verify", certPath)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return errors.New(strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// planAdd resolves what adding the content at uri would install without
+// changing the system. It doesn't need the statedir lock as swupd only gets
+// a scratch state directory, the certificate it checked is kept in the plan
+// so the add installs with the same one that was shown.
+func planAdd(uri string, contentdir string) (AddPlan, error) {
+	plan := AddPlan{URI: uri}
+	tmpdir, err := ioutil.TempDir("", "3rd-party-")
 	if err != nil {
-		log.Fatalf("Unable to get format from filesystem: %s", err)
+		return plan, err
 	}
-	version, err := cublib.GetVersion(uri, statedir)
+	defer os.RemoveAll(tmpdir)
+	version, err := cublib.GetVersion(uri, tmpdir)
 	if err != nil {
-		log.Fatalf("Unable to get version from uri (%s): %s", uri, err)
+		return plan, fmt.Errorf("Unable to get version from uri (%s): %s", uri, err)
 	}
+	plan.Version = version
 
 	configBasename := "user-config.toml"
 	postfix := path.Join("/", version, configBasename)
 	configURI := uri + postfix
 	config, err := cublib.GetConfig(configURI)
 	if err != nil {
-		log.Fatalf("Error accessing configuration from (%s): %s", configURI, err)
+		return plan, fmt.Errorf("Error accessing configuration from (%s): %s", configURI, err)
+	}
+	// Installed bundles get away with problems in their config for backward
+	// compatibility, new ones have to be right
+	if problems := config.Bundle.Validate(); len(problems) > 0 {
+		msgs := []string{}
+		for _, p := range problems {
+			msgs = append(msgs, p.Error())
+		}
+		return plan, fmt.Errorf("Invalid configuration (%s): %s", configURI, strings.Join(msgs, ", "))
+	}
+	plan.config = config
+	plan.ID = cublib.GetEncodedBundleName(config.Bundle.URL, config.Bundle.Name)
+	plan.Name = config.Bundle.Name
+	plan.Description = config.Bundle.Description
+	plan.URL = config.Bundle.URL
+	plan.Applications = []string{}
+	for _, app := range config.Bundle.Apps() {
+		plan.Applications = append(plan.Applications, app.Target)
+	}
+	// Patterns are only expanded once the content is installed
+	plan.Applications = append(plan.Applications, config.Bundle.Patterns()...)
+	plan.HostBundles = []string{}
+	for _, include := range config.Bundle.Includes {
+		if !cublib.IsHostBundleInstalled(include) {
+			plan.HostBundles = append(plan.HostBundles, include)
+		}
+	}
+	if _, err = os.Stat(path.Join(contentdir, "chroot", plan.ID) + ".toml"); err == nil {
+		plan.Installed = true
 	}
 
+	certURI := config.Bundle.URL + path.Join("/", version, "Swupd_Root.pem")
+	certPath, err := cublib.GetCert(tmpdir, certURI)
+	if err != nil {
+		return plan, fmt.Errorf("Unable to load certificate (%s): %s", certURI, err)
+	}
+	cert, err := cublib.GetCertInfo(certPath)
+	if err != nil {
+		return plan, fmt.Errorf("Unable to parse certificate (%s): %s", certURI, err)
+	}
+	plan.Signer = cert.Subject
+	plan.Fingerprint = cert.Fingerprint
+	if err = verifyCert(certPath); err != nil {
+		plan.TrustError = err.Error()
+	} else {
+		plan.Trusted = true
+	}
+	if plan.cert, err = ioutil.ReadFile(certPath); err != nil {
+		return plan, fmt.Errorf("Unable to read certificate (%s): %s", certURI, err)
+	}
+
+	manifest, err := cublib.GetBundleManifest(config.Bundle.URL, version, config.Bundle.Name)
+	if err != nil {
+		log.Printf("WARNING: Unable to load manifest for %s version %s: %s", config.Bundle.Name, version, err)
+	} else {
+		plan.ContentSize = manifest.ContentSize
+	}
+	return plan, nil
+}
+
+func printAddPlan(plan AddPlan) {
+	fmt.Printf("Name:              %-28s\n", plan.Name)
+	fmt.Printf("ID:                %-28s\n", plan.ID)
+	fmt.Printf("Description:       %-28s\n", plan.Description)
+	fmt.Printf("URL:               %-28s\n", plan.URL)
+	fmt.Printf("Version:           %-28s\n", plan.Version)
+	fmt.Printf("Signer:            %-28s\n", plan.Signer)
+	fmt.Printf("Fingerprint:       %-28s\n", plan.Fingerprint)
+	if plan.Trusted {
+		fmt.Printf("Trusted:           %-28s\n", "yes")
+	} else {
+		fmt.Printf("Trusted:           no (%s)\n", plan.TrustError)
+	}
+	if plan.ContentSize > 0 {
+		fmt.Printf("Installed size:    %-28s\n", formatSize(plan.ContentSize))
+	}
+	if len(plan.HostBundles) > 0 {
+		fmt.Println("Host bundles to add:")
+		for _, include := range plan.HostBundles {
+			fmt.Printf("                   %-28s\n", include)
+		}
+	}
+	if len(plan.Applications) > 0 {
+		fmt.Println("Applications:")
+		for _, app := range plan.Applications {
+			fmt.Printf("                   %-28s\n", app)
+		}
+	}
+	if plan.Installed {
+		fmt.Println("WARNING: bundle is already installed")
+	}
+}
+
+// confirmAdd shows the plan and asks the user whether to go ahead with it.
+func confirmAdd(plan AddPlan) bool {
+	printAddPlan(plan)
+	fmt.Print("\nAdd this 3rd-party bundle? [y/N]: ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// recordAdd saves the state of a newly added bundle.
+func recordAdd(statedir string, id string, plan AddPlan) error {
+	state, err := cublib.LoadState(statedir)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	bs := &cublib.BundleState{
+		ID:               id,
+		URL:              plan.config.Bundle.URL,
+		Name:             plan.config.Bundle.Name,
+		InstalledVersion: plan.Version,
+		Installed:        now,
+		Updated:          now,
+		CertFingerprint:  plan.Fingerprint,
+		Includes:         plan.HostBundles,
+	}
+	bs.RecordAttempt(now, nil)
+	bs.RecordLatest(now, plan.Version)
+	state.Remove(id)
+	state.Bundles = append(state.Bundles, bs)
+	return state.Save(statedir)
+}
+
+// failAdd records the failed add and exits.
+func failAdd(statedir string, entry cublib.HistoryEntry, format string, args ...interface{}) {
+	entry.Finish(fmt.Errorf(format, args...))
+	recordHistory(statedir, entry)
+	log.Fatalf(format, args...)
+}
+
+// abortAdd removes what was installed of a bundle that failed to be added,
+// records the failure and exits.
+func abortAdd(statedir string, contentdir string, settingsPath string, entry cublib.HistoryEntry, format string, args ...interface{}) {
+	if err := Remove(statedir, contentdir, settingsPath, entry.URL, entry.Name, false, false); err != nil {
+		log.Printf("WARNING: Unable to clean up after failed add: %s", err)
+	}
+	failAdd(statedir, entry, format, args...)
+}
+
+func Add(uri string, statedir string, contentdir string, settingsPath string, skipPost bool, dryRun bool, assumeYes bool, output string) {
+	plan, err := planAdd(uri, contentdir)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if dryRun {
+		printResult(output, plan, func() {
+			printAddPlan(plan)
+		})
+		return
+	}
+	// 3rd-party content runs as the user and its includes are installed as root
+	// so never add it without the user having seen what it is
+	if !assumeYes {
+		if !cublib.IsTerminal(os.Stdin.Fd()) {
+			log.Fatalf("Refusing to add %s without confirmation, use --yes when not running interactively", uri)
+		}
+		if !confirmAdd(plan) {
+			log.Fatalf("Aborted adding %s", uri)
+		}
+	}
+
+	// Only lock once confirmed so a pending prompt doesn't hold up other
+	// operations, the plan pins what is installed so only need to recheck
+	// nothing else added the bundle in the meantime
+	// GetLock causes program exit on failure to acquire lockfile
+	cublib.GetLock(statedir)
+	defer cublib.ReleaseLock(statedir)
+
+	entry := cublib.NewHistoryEntry(cublib.OpAdd)
+	entry.ID = plan.ID
+	entry.Name = plan.Name
+	entry.URL = plan.URL
+	entry.ToVersion = plan.Version
+
+	format, err := cublib.GetFormat()
+	if err != nil {
+		failAdd(statedir, entry, "Unable to get format from filesystem: %s", err)
+	}
+	version := plan.Version
+	config := plan.config
+
 	if config.Bundle.URL != uri {
 		log.Printf("WARNING: bundle configured url (%s) and url used to add bundle (%s) differ", config.Bundle.URL, uri)
 	}
@@ -51,54 +277,46 @@ func Add(uri string, statedir string, contentdir string, skipPost bool) {
 	chrootdir := path.Join(contentdir, "chroot")
 	err = os.MkdirAll(chrootdir, 0755)
 	if err != nil {
-		log.Fatalf("Unable to make toplevel 3rd party content directory (%s): %s", contentdir, err)
+		failAdd(statedir, entry, "Unable to make toplevel 3rd party content directory (%s): %s", contentdir, err)
 	}
 
 	bnameEncoded := cublib.GetEncodedBundleName(config.Bundle.URL, config.Bundle.Name)
 	pstatedir := path.Join(statedir, "3rd-party", bnameEncoded)
 	err = os.MkdirAll(pstatedir, 0700)
 	if err != nil {
-		log.Fatalf("Unable to make 3rd party state directory (%s): %s", pstatedir, err)
+		failAdd(statedir, entry, "Unable to make 3rd party state directory (%s): %s", pstatedir, err)
 	}
 	configPath := path.Join(chrootdir, bnameEncoded) + ".toml"
 	if _, err = os.Stat(configPath); !os.IsNotExist(err) {
-		log.Fatalf("Config %s already exists, exiting", configPath)
+		failAdd(statedir, entry, "Config %s already exists, exiting", configPath)
 	}
 	err = cublib.WriteConfig(configPath, config, false)
 	if err != nil {
-		Remove(statedir, contentdir, config.Bundle.URL, config.Bundle.Name, false, false)
-		log.Fatalf("Unable to save bundle configuration file to 3rd party state directory (%s): %s", pstatedir, err)
+		abortAdd(statedir, contentdir, settingsPath, entry, "Unable to save bundle configuration file to 3rd party state directory (%s): %s", pstatedir, err)
 	}
 
 	pchrootdir := path.Join(chrootdir, bnameEncoded)
 	if _, err = os.Stat(pchrootdir); !os.IsNotExist(err) {
-		log.Fatalf("Content path %s already exists, try running remove operation on partially installed content", pchrootdir)
+		failAdd(statedir, entry, "Content path %s already exists, try running remove operation on partially installed content", pchrootdir)
 	}
 	err = os.MkdirAll(pchrootdir, 0755)
 	if err != nil {
-		Remove(statedir, contentdir, config.Bundle.URL, config.Bundle.Name, false, false)
-		log.Fatalf("Unable to make 3rd party state directory (%s): %s", pstatedir, err)
+		abortAdd(statedir, contentdir, settingsPath, entry, "Unable to make 3rd party state directory (%s): %s", pstatedir, err)
 	}
 
 	certURI := config.Bundle.URL + path.Join("/", version, "Swupd_Root.pem")
-	certPath, err := cublib.GetCert(pstatedir, certURI)
-	if err != nil {
-		Remove(statedir, contentdir, config.Bundle.URL, config.Bundle.Name, false, false)
-		log.Fatalf("Unable to load certificate (%s): %s", certURI, err)
+	certPath := path.Join(pstatedir, "Swupd_Root.pem")
+	if err = ioutil.WriteFile(certPath, plan.cert, 0644); err != nil {
+		abortAdd(statedir, contentdir, settingsPath, entry, "Unable to save certificate (%s): %s", certURI, err)
 	}
 
-	var cmd *exec.Cmd
-	out := bytes.Buffer{}
-	cmd = exec.Command("openssl", "verify", certPath)
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-	err = cmd.Run()
-	if err != nil {
-		Remove(statedir, contentdir, config.Bundle.URL, config.Bundle.Name, false, false)
-		log.Printf("Certificate (%s) isn't trusted: %s", certURI, out.String())
-		log.Fatalf("Please add certificate to trust chain")
+	if err = verifyCert(certPath); err != nil {
+		abortAdd(statedir, contentdir, settingsPath, entry, "Certificate (%s) isn't trusted: %s, please add certificate to trust chain", certURI, err)
 	}
 
+	var cmd *exec.Cmd
+	var out bytes.Buffer
+
 	if len(config.Bundle.Includes) > 0 {
 		cmd = exec.Command("swupd", append([]string{"bundle-add"}, config.Bundle.Includes...)...)
 		out = bytes.Buffer{}
@@ -106,8 +324,7 @@ func Add(uri string, statedir string, contentdir string, skipPost bool) {
 		cmd.Stderr = &out
 		err = cmd.Run()
 		if err != nil {
-			Remove(statedir, contentdir, config.Bundle.URL, config.Bundle.Name, false, false)
-			log.Fatalf("Unable to install dependency bundle(s) %s to the base system: %s", config.Bundle.Includes, out.String())
+			abortAdd(statedir, contentdir, settingsPath, entry, "Unable to install dependency bundle(s) %s to the base system: %s", config.Bundle.Includes, out.String())
 		}
 	}
 
@@ -118,17 +335,21 @@ func Add(uri string, statedir string, contentdir string, skipPost bool) {
 	cmd.Stderr = &out
 	err = cmd.Run()
 	if err != nil {
-		Remove(statedir, contentdir, config.Bundle.URL, config.Bundle.Name, false, false)
-		log.Fatalf("Unable to install bundle %s from %s: %s", config.Bundle.Name, config.Bundle.URL, out.String())
+		abortAdd(statedir, contentdir, settingsPath, entry, "Unable to install bundle %s from %s: %s", config.Bundle.Name, config.Bundle.URL, out.String())
 	}
 	if err = os.Remove(certPath); err != nil {
 		log.Printf("WARNING: Unable to remove temporary cert (%s): %s", certPath, err)
 	}
+	if err = recordAdd(statedir, bnameEncoded, plan); err != nil {
+		log.Printf("WARNING: Unable to record %s in 3rd-party state: %s", config.Bundle.Name, err)
+	}
 
-	if skipPost {
-		return
+	if !skipPost {
+		err = cublib.PostProcess(statedir, contentdir, settingsPath)
 	}
-	if err = cublib.PostProcess(statedir, contentdir); err != nil {
-			log.Fatalf("%s", err)
+	entry.Finish(err)
+	recordHistory(statedir, entry)
+	if err != nil {
+		log.Fatalf("%s", err)
 	}
 }
@@ -0,0 +1,108 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operations
+
+import (
+	"fmt"
+	"log"
+	"github.com/clearlinux/clr-user-bundles/cublib"
+)
+
+type AlternativesResult struct {
+	Alternatives []cublib.Collision `json:"alternatives" toml:"alternative"`
+}
+
+// Alternatives lists the exported commands provided by more than one bundle
+// or shadowed by a host command, only command when it is set.
+func Alternatives(statedir string, contentdir string, settingsPath string, command string, output string) {
+	// GetLock causes program exit on failure to acquire lockfile
+	cublib.GetLock(statedir)
+	defer cublib.ReleaseLock(statedir)
+	settings, err := cublib.LoadSettings(settingsPath)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	_, collisions, err := cublib.ResolveExports(statedir, contentdir, settings)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	result := AlternativesResult{Alternatives: []cublib.Collision{}}
+	for _, c := range collisions {
+		if command == "" || c.Command == command {
+			result.Alternatives = append(result.Alternatives, c)
+		}
+	}
+
+	printResult(output, result, func() {
+		for _, c := range result.Alternatives {
+			fmt.Println(c.Command)
+			for i, p := range c.Providers {
+				marker := " "
+				if i == 0 {
+					marker = "*"
+				}
+				fmt.Printf("  %s %-26s %s\n", marker, p.Name, p.Target)
+			}
+			if c.Contested() {
+				fmt.Printf("    Chosen by:   %s\n", c.Reason)
+			}
+			if c.Host != "" {
+				fmt.Printf("    Shadowed by: %s\n", c.Host)
+			}
+		}
+	})
+}
+
+// SetAlternative chooses the bundle name to export command when several
+// bundles do. An empty name drops the choice so the priority order applies.
+func SetAlternative(statedir string, contentdir string, settingsPath string, command string, name string, skipPost bool) {
+	// GetLock causes program exit on failure to acquire lockfile
+	cublib.GetLock(statedir)
+	defer cublib.ReleaseLock(statedir)
+	state, err := cublib.LoadState(statedir)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	id := ""
+	if name != "" {
+		bundle, err := cublib.FindBundle(contentdir, name)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		apps, _ := cublib.ResolveApps(bundle.ChrootDir(contentdir), bundle.Config.Bundle)
+		found := false
+		for _, app := range apps {
+			if app.Name == command {
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Fatalf("Bundle %s doesn't export %s", name, command)
+		}
+		id = bundle.ID
+	}
+	state.SetAlternative(command, id)
+	if err = state.Save(statedir); err != nil {
+		log.Fatalf("Unable to save 3rd-party state: %s", err)
+	}
+
+	if !skipPost {
+		if err = cublib.PostProcess(statedir, contentdir, settingsPath); err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+}
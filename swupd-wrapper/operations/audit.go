@@ -0,0 +1,145 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operations
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"github.com/clearlinux/clr-user-bundles/cublib"
+)
+
+type AuditFile struct {
+	Path   string `json:"path" toml:"path"`
+	Status string `json:"status" toml:"status"`
+}
+
+type AuditBundle struct {
+	ID      string      `json:"id" toml:"id"`
+	Name    string      `json:"name" toml:"name"`
+	Version string      `json:"version" toml:"version"`
+	Checked int         `json:"checked" toml:"checked"`
+	Files   []AuditFile `json:"files" toml:"file"`
+	Error   string      `json:"error" toml:"error"`
+}
+
+type AuditResult struct {
+	Bundles []AuditBundle `json:"bundles" toml:"bundle"`
+}
+
+// auditBundle hashes every file in the bundle chroot and compares it with the
+// cached manifest of the installed version, without network access.
+func auditBundle(statedir string, contentdir string, bundle cublib.Bundle) AuditBundle {
+	pchrootdir := bundle.ChrootDir(contentdir)
+	result := AuditBundle{ID: bundle.ID, Name: bundle.Config.Bundle.Name, Files: []AuditFile{}}
+	version, err := cublib.GetInstalledVersion(pchrootdir)
+	if err != nil {
+		result.Error = fmt.Sprintf("Unable to read installed version (%s): %s", pchrootdir, err)
+		return result
+	}
+	result.Version = version
+	m, err := cublib.GetCachedManifest(bundle.StateDir(statedir), version, bundle.Config.Bundle.Name)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	tracked := make(map[string]bool)
+	for _, f := range m.Files {
+		tracked[f.Path] = true
+		if f.IsDeleted() || f.IsGhosted() {
+			continue
+		}
+		result.Checked++
+		hash, err := cublib.GetHash(path.Join(pchrootdir, f.Path))
+		if os.IsNotExist(err) {
+			result.Files = append(result.Files, AuditFile{Path: f.Path, Status: "missing"})
+		} else if err != nil {
+			result.Files = append(result.Files, AuditFile{Path: f.Path, Status: "unreadable"})
+		} else if hash != f.Hash {
+			result.Files = append(result.Files, AuditFile{Path: f.Path, Status: "modified"})
+		}
+	}
+	err = filepath.Walk(pchrootdir, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fpath == pchrootdir {
+			return nil
+		}
+		cpath := "/" + fpath[len(pchrootdir)+1:]
+		if !tracked[cpath] {
+			result.Files = append(result.Files, AuditFile{Path: cpath, Status: "untracked"})
+		}
+		return nil
+	})
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func Audit(statedir string, contentdir string, name string, output string) {
+	// GetLock causes program exit on failure to acquire lockfile
+	cublib.GetLock(statedir)
+	defer cublib.ReleaseLock(statedir)
+	var bundles []cublib.Bundle
+	if name != "" {
+		bundle, err := cublib.FindBundle(contentdir, name)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		bundles = append(bundles, bundle)
+	} else {
+		var err error
+		if bundles, err = cublib.GetBundles(contentdir); err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+
+	result := AuditResult{Bundles: []AuditBundle{}}
+	failed := false
+	for _, b := range bundles {
+		a := auditBundle(statedir, contentdir, b)
+		if a.Error != "" {
+			log.Printf("WARNING: Unable to audit (%s %s): %s", b.Config.Bundle.URL, b.Config.Bundle.Name, a.Error)
+		}
+		if a.Error != "" || len(a.Files) > 0 {
+			failed = true
+		}
+		result.Bundles = append(result.Bundles, a)
+	}
+
+	printResult(output, result, func() {
+		for _, b := range result.Bundles {
+			state := fmt.Sprintf("%d file(s) ok", b.Checked)
+			if b.Error != "" {
+				state = "audit failed"
+			} else if len(b.Files) > 0 {
+				state = fmt.Sprintf("%d problem(s) in %d file(s)", len(b.Files), b.Checked)
+			}
+			fmt.Printf("%-28s %-8s %s\n", b.Name, orUnknown(b.Version), state)
+			for _, f := range b.Files {
+				fmt.Printf("  %-10s %s\n", f.Status, f.Path)
+			}
+		}
+	})
+	if failed {
+		cublib.ReleaseLock(statedir)
+		os.Exit(1)
+	}
+}
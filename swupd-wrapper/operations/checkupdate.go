@@ -0,0 +1,127 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operations
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+	"github.com/clearlinux/clr-user-bundles/cublib"
+)
+
+type CheckUpdateBundle struct {
+	ID               string `json:"id" toml:"id"`
+	Name             string `json:"name" toml:"name"`
+	URL              string `json:"url" toml:"url"`
+	InstalledVersion string `json:"installed_version" toml:"installed_version"`
+	LatestVersion    string `json:"latest_version" toml:"latest_version"`
+	UpdateAvailable  bool   `json:"update_available" toml:"update_available"`
+	UpdateVersion    string `json:"update_version" toml:"update_version"`
+	Defer            string `json:"defer" toml:"defer"`
+	DeferredUntil    string `json:"deferred_until" toml:"deferred_until"`
+	Hold             bool   `json:"hold" toml:"hold"`
+	Error            string `json:"error" toml:"error"`
+}
+
+type CheckUpdateResult struct {
+	Bundles []CheckUpdateBundle `json:"bundles" toml:"bundle"`
+}
+
+// newerVersion reports whether latest is a higher version than installed.
+func newerVersion(installed string, latest string) bool {
+	i, err := strconv.Atoi(installed)
+	if err != nil {
+		return latest != "" && latest != installed
+	}
+	l, err := strconv.Atoi(latest)
+	if err != nil {
+		return false
+	}
+	return l > i
+}
+
+func CheckUpdate(statedir string, contentdir string, settingsPath string, output string) {
+	// GetLock causes program exit on failure to acquire lockfile
+	cublib.GetLock(statedir)
+	defer cublib.ReleaseLock(statedir)
+	bundles, err := cublib.GetBundles(contentdir)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	state, err := cublib.LoadState(statedir)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	settings, err := cublib.LoadSettings(settingsPath)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	result := CheckUpdateResult{Bundles: []CheckUpdateBundle{}}
+	for _, b := range bundles {
+		pchrootdir := b.ChrootDir(contentdir)
+		pstatedir := b.StateDir(statedir)
+		entry := CheckUpdateBundle{
+			ID:   b.ID,
+			Name: b.Config.Bundle.Name,
+			URL:  b.Config.Bundle.URL,
+		}
+		bs := state.Track(b, statedir, contentdir)
+		entry.Hold = bs.Hold
+		if entry.InstalledVersion, err = cublib.GetInstalledVersion(pchrootdir); err != nil {
+			log.Printf("WARNING: Unable to read installed version (%s): %s", pchrootdir, err)
+		}
+		now := time.Now()
+		deferFor := settings.DeferFor(b)
+		var until time.Time
+		entry.UpdateVersion, entry.LatestVersion, until, err = updateTarget(b, pstatedir, entry.InstalledVersion, deferFor, now)
+		if err != nil {
+			log.Printf("WARNING: %s", err)
+			entry.Error = err.Error()
+		} else {
+			entry.UpdateAvailable = newerVersion(entry.InstalledVersion, entry.LatestVersion)
+			bs.RecordLatest(now, entry.LatestVersion)
+			bs.DeferredUntil = until
+		}
+		if deferFor > 0 {
+			entry.Defer = deferFor.String()
+			entry.DeferredUntil = formatTimestamp(until)
+		}
+		result.Bundles = append(result.Bundles, entry)
+	}
+	if err = state.Save(statedir); err != nil {
+		log.Printf("WARNING: Unable to save 3rd-party state: %s", err)
+	}
+
+	printResult(output, result, func() {
+		for _, b := range result.Bundles {
+			state := "up to date"
+			if b.Error != "" {
+				state = "check failed"
+			} else if b.DeferredUntil != "" && b.UpdateVersion != b.InstalledVersion {
+				state = fmt.Sprintf("update to %s available, %s deferred until %s (defer %s)", b.UpdateVersion, b.LatestVersion, b.DeferredUntil, b.Defer)
+			} else if b.DeferredUntil != "" {
+				state = fmt.Sprintf("update deferred until %s (defer %s)", b.DeferredUntil, b.Defer)
+			} else if b.UpdateAvailable {
+				state = "update available"
+			}
+			if b.Hold {
+				state += " (held)"
+			}
+			fmt.Printf("%-28s %8s -> %-8s %s\n", b.Name, orUnknown(b.InstalledVersion), orUnknown(b.LatestVersion), state)
+		}
+	})
+}
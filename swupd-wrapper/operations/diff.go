@@ -0,0 +1,245 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operations
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"time"
+	"github.com/clearlinux/clr-user-bundles/cublib"
+)
+
+type DiffResult struct {
+	ID               string   `json:"id" toml:"id"`
+	Name             string   `json:"name" toml:"name"`
+	InstalledVersion string   `json:"installed_version" toml:"installed_version"`
+	LatestVersion    string   `json:"latest_version" toml:"latest_version"`
+	TargetVersion    string   `json:"target_version" toml:"target_version"`
+	DeferredUntil    string   `json:"deferred_until" toml:"deferred_until"`
+	Added            []string `json:"added" toml:"added"`
+	Removed          []string `json:"removed" toml:"removed"`
+	Modified         []string `json:"modified" toml:"modified"`
+	DownloadSize     int64    `json:"download_size" toml:"download_size"`
+	IncludesAdded    []string `json:"includes_added" toml:"includes_added"`
+	IncludesRemoved  []string `json:"includes_removed" toml:"includes_removed"`
+	BinAdded         []string `json:"bin_added" toml:"bin_added"`
+	BinRemoved       []string `json:"bin_removed" toml:"bin_removed"`
+	Signer           string   `json:"signer" toml:"signer"`
+	NewSigner        string   `json:"new_signer" toml:"new_signer"`
+	SignerChanged    bool     `json:"signer_changed" toml:"signer_changed"`
+	Error            string   `json:"error" toml:"error"`
+}
+
+type DiffListResult struct {
+	Bundles []DiffResult `json:"bundles" toml:"bundle"`
+}
+
+// listChanges returns the entries only in new and the entries only in old.
+func listChanges(old []string, new []string) (added []string, removed []string) {
+	seen := make(map[string]bool)
+	for _, o := range old {
+		seen[o] = true
+	}
+	for _, n := range new {
+		if !seen[n] {
+			added = append(added, n)
+		}
+		delete(seen, n)
+	}
+	for _, o := range old {
+		if seen[o] {
+			removed = append(removed, o)
+		}
+	}
+	return added, removed
+}
+
+// appList describes the applications exported by a bundle config.
+func appList(c cublib.BundleConfig) []string {
+	apps := []string{}
+	for _, app := range c.Apps() {
+		apps = append(apps, app.String())
+	}
+	return append(apps, c.Patterns()...)
+}
+
+// bundleDiff compares the installed content of bundle with the latest
+// version available, or the newest version at least deferFor old, without
+// modifying anything on the system.
+func bundleDiff(statedir string, contentdir string, bundle cublib.Bundle, deferFor time.Duration) (DiffResult, error) {
+	result, err := compareLatest(statedir, contentdir, bundle, deferFor)
+	for _, list := range []*[]string{&result.Added, &result.Removed, &result.Modified,
+		&result.IncludesAdded, &result.IncludesRemoved, &result.BinAdded, &result.BinRemoved} {
+		*list = emptyIfNil(*list)
+	}
+	return result, err
+}
+
+func compareLatest(statedir string, contentdir string, bundle cublib.Bundle, deferFor time.Duration) (DiffResult, error) {
+	pchrootdir := bundle.ChrootDir(contentdir)
+	uri := bundle.Config.Bundle.URL
+	name := bundle.Config.Bundle.Name
+	result := DiffResult{ID: bundle.ID, Name: name}
+
+	oldManifest, err := installedManifest(statedir, contentdir, bundle)
+	if err != nil {
+		return result, err
+	}
+	result.InstalledVersion = oldManifest.Version
+	var until time.Time
+	result.TargetVersion, result.LatestVersion, until, err = updateTarget(bundle, bundle.StateDir(statedir), result.InstalledVersion, deferFor, time.Now())
+	result.DeferredUntil = formatTimestamp(until)
+	if err != nil {
+		return result, err
+	}
+	if result.TargetVersion == result.InstalledVersion {
+		return result, nil
+	}
+
+	newManifest, err := cublib.GetBundleManifest(uri, result.TargetVersion, name)
+	if err != nil {
+		return result, fmt.Errorf("Unable to load manifest for %s version %s: %s", name, result.TargetVersion, err)
+	}
+	result.Added, result.Removed, result.Modified = cublib.DiffManifests(oldManifest, newManifest)
+	result.DownloadSize = newManifest.ContentSize
+
+	oldConfig, err := cublib.GetUpdatedConfig(pchrootdir)
+	if err != nil {
+		return result, fmt.Errorf("Unable to read installed 3rd-party config (%s): %s", pchrootdir, err)
+	}
+	configURI := uri + path.Join("/", result.TargetVersion, "user-config.toml")
+	newConfig, err := cublib.GetConfig(configURI)
+	if err != nil {
+		return result, fmt.Errorf("Error accessing configuration from (%s): %s", configURI, err)
+	}
+	result.IncludesAdded, result.IncludesRemoved = listChanges(oldConfig.Bundle.Includes, newConfig.Bundle.Includes)
+	result.BinAdded, result.BinRemoved = listChanges(appList(oldConfig.Bundle), appList(newConfig.Bundle))
+
+	// The new certificate is compared with the one recorded for the bundle,
+	// which update warns about changes from as well
+	state, err := cublib.LoadState(statedir)
+	if err != nil {
+		return result, err
+	}
+	result.Signer = state.Track(bundle, statedir, contentdir).CertFingerprint
+	certURI := uri + path.Join("/", result.TargetVersion, "Swupd_Root.pem")
+	newCert, err := cublib.GetRemoteCertInfo(certURI)
+	if err != nil {
+		return result, fmt.Errorf("Unable to load certificate (%s): %s", certURI, err)
+	}
+	result.NewSigner = newCert.Fingerprint
+	result.SignerChanged = result.Signer != newCert.Fingerprint
+	return result, nil
+}
+
+func printDiff(d DiffResult) {
+	fmt.Printf("Name:              %-28s\n", d.Name)
+	fmt.Printf("ID:                %-28s\n", d.ID)
+	fmt.Printf("Installed version: %-28s\n", orUnknown(d.InstalledVersion))
+	fmt.Printf("Latest version:    %-28s\n", orUnknown(d.LatestVersion))
+	if d.DeferredUntil != "" {
+		fmt.Printf("Deferred until:    %-28s\n", d.DeferredUntil)
+		fmt.Printf("Update version:    %-28s\n", orUnknown(d.TargetVersion))
+	}
+	if d.Error != "" {
+		fmt.Printf("Error:             %-28s\n", d.Error)
+		return
+	}
+	if d.TargetVersion == d.InstalledVersion {
+		fmt.Println("Up to date")
+		return
+	}
+	fmt.Printf("Download size:     %-28d\n", d.DownloadSize)
+	if d.SignerChanged {
+		fmt.Printf("Signer changes:    %s -> %s\n", d.Signer, d.NewSigner)
+	}
+	type change struct {
+		prefix string
+		items  []string
+	}
+	printChanges := func(title string, changes ...change) {
+		printed := false
+		for _, c := range changes {
+			for _, item := range c.items {
+				if !printed {
+					fmt.Println(title)
+					printed = true
+				}
+				fmt.Printf("  %s %s\n", c.prefix, item)
+			}
+		}
+	}
+	printChanges("Included bundles:", change{"+", d.IncludesAdded}, change{"-", d.IncludesRemoved})
+	printChanges("Applications:", change{"+", d.BinAdded}, change{"-", d.BinRemoved})
+	printChanges("Files:", change{"A", d.Added}, change{"D", d.Removed}, change{"M", d.Modified})
+}
+
+func Diff(statedir string, contentdir string, name string, output string) {
+	// GetLock causes program exit on failure to acquire lockfile
+	cublib.GetLock(statedir)
+	defer cublib.ReleaseLock(statedir)
+	bundle, err := cublib.FindBundle(contentdir, name)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	result, err := bundleDiff(statedir, contentdir, bundle, 0)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	printResult(output, result, func() {
+		printDiff(result)
+	})
+}
+
+// UpdateDryRun reports what Update would change for every bundle that isn't held.
+func UpdateDryRun(statedir string, contentdir string, settingsPath string, output string) {
+	// GetLock causes program exit on failure to acquire lockfile
+	cublib.GetLock(statedir)
+	defer cublib.ReleaseLock(statedir)
+	bundles, err := cublib.GetBundles(contentdir)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	state, err := cublib.LoadState(statedir)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	settings, err := cublib.LoadSettings(settingsPath)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	result := DiffListResult{Bundles: []DiffResult{}}
+	for _, b := range bundles {
+		if state.Track(b, statedir, contentdir).Hold {
+			log.Printf("Skipping held bundle (%s %s)", b.Config.Bundle.URL, b.Config.Bundle.Name)
+			continue
+		}
+		d, err := bundleDiff(statedir, contentdir, b, settings.DeferFor(b))
+		if err != nil {
+			log.Printf("WARNING: Unable to check update (%s %s): %s", b.Config.Bundle.URL, b.Config.Bundle.Name, err)
+			d.Error = err.Error()
+		}
+		result.Bundles = append(result.Bundles, d)
+	}
+	printResult(output, result, func() {
+		for i, d := range result.Bundles {
+			if i > 0 {
+				fmt.Println("")
+			}
+			printDiff(d)
+		}
+	})
+}
@@ -0,0 +1,293 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operations
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"github.com/clearlinux/clr-user-bundles/cublib"
+)
+
+// Kinds of inconsistencies reported by Doctor.
+const (
+	problemOrphanContent  = "orphan-content"
+	problemOrphanConfig   = "orphan-config"
+	problemOrphanState    = "orphan-state"
+	problemBadConfig      = "unreadable-config"
+	problemIDMismatch     = "id-mismatch"
+	problemLeftoverCert   = "leftover-cert"
+	problemDanglingExport = "dangling-wrapper"
+	problemStaleRecord    = "stale-record"
+	problemStaleExport    = "stale-wrapper"
+)
+
+type DoctorProblem struct {
+	Kind        string `json:"kind" toml:"kind"`
+	ID          string `json:"id" toml:"id"`
+	Path        string `json:"path" toml:"path"`
+	Description string `json:"description" toml:"description"`
+	Fixed       bool   `json:"fixed" toml:"fixed"`
+	Error       string `json:"error" toml:"error"`
+}
+
+type DoctorResult struct {
+	Problems []DoctorProblem `json:"problems" toml:"problem"`
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// removeBundleFiles deletes every trace of the bundle id.
+func removeBundleFiles(statedir string, contentdir string, id string) error {
+	b := cublib.Bundle{ID: id}
+	for _, p := range []string{b.StateDir(statedir), b.ChrootDir(contentdir), b.ConfigPath(contentdir)} {
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	return removeRecord(statedir, id)
+}
+
+// removeRecord drops the bundle id from the state database.
+func removeRecord(statedir string, id string) error {
+	state, err := cublib.LoadState(statedir)
+	if err != nil {
+		return err
+	}
+	if state.Bundle(id) == nil {
+		return nil
+	}
+	state.Remove(id)
+	return state.Save(statedir)
+}
+
+// diagnose cross-checks the bundle chroots, configs, state directories and
+// exported applications.
+func diagnose(statedir string, contentdir string) []DoctorProblem {
+	problems := []DoctorProblem{}
+	chrootdir := path.Join(contentdir, "chroot")
+	pstatedir := path.Join(statedir, "3rd-party")
+
+	dirs := make(map[string]bool)
+	configs := make(map[string]bool)
+	if dlist, err := ioutil.ReadDir(chrootdir); err == nil {
+		for _, p := range dlist {
+			if filepath.Ext(p.Name()) == ".toml" {
+				configs[p.Name()[:len(p.Name())-len(".toml")]] = true
+			} else if p.IsDir() {
+				dirs[p.Name()] = true
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("Unable to read 3rd-party content directory (%s): %s", chrootdir, err)
+	}
+
+	for _, id := range sortedKeys(dirs) {
+		b := cublib.Bundle{ID: id}
+		if !configs[id] {
+			problems = append(problems, DoctorProblem{Kind: problemOrphanContent, ID: id, Path: b.ChrootDir(contentdir),
+				Description: "bundle content has no config"})
+			continue
+		}
+		conf, err := cublib.GetConfig("file://" + b.ConfigPath(contentdir))
+		if err != nil {
+			problems = append(problems, DoctorProblem{Kind: problemBadConfig, ID: id, Path: b.ConfigPath(contentdir),
+				Description: fmt.Sprintf("config can't be read: %s", err)})
+			continue
+		}
+		if encoded := cublib.GetEncodedBundleName(conf.Bundle.URL, conf.Bundle.Name); encoded != id {
+			problems = append(problems, DoctorProblem{Kind: problemIDMismatch, ID: id, Path: b.ConfigPath(contentdir),
+				Description: fmt.Sprintf("config is for %s from %s which has ID %s", conf.Bundle.Name, conf.Bundle.URL, encoded)})
+		}
+	}
+	for _, id := range sortedKeys(configs) {
+		if !dirs[id] {
+			b := cublib.Bundle{ID: id}
+			problems = append(problems, DoctorProblem{Kind: problemOrphanConfig, ID: id, Path: b.ConfigPath(contentdir),
+				Description: "config has no bundle content"})
+		}
+	}
+
+	if slist, err := ioutil.ReadDir(pstatedir); err == nil {
+		for _, p := range slist {
+			if !p.IsDir() {
+				continue
+			}
+			id := p.Name()
+			b := cublib.Bundle{ID: id}
+			if !dirs[id] && !configs[id] {
+				problems = append(problems, DoctorProblem{Kind: problemOrphanState, ID: id, Path: b.StateDir(statedir),
+					Description: "state directory has no bundle content or config"})
+				continue
+			}
+			certPath := path.Join(b.StateDir(statedir), "Swupd_Root.pem")
+			if _, err := os.Lstat(certPath); err == nil {
+				problems = append(problems, DoctorProblem{Kind: problemLeftoverCert, ID: id, Path: certPath,
+					Description: "temporary certificate from an interrupted add"})
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("Unable to read 3rd-party state directory (%s): %s", pstatedir, err)
+	}
+
+	if state, err := cublib.LoadState(statedir); err == nil {
+		for _, bs := range state.Bundles {
+			if !dirs[bs.ID] {
+				problems = append(problems, DoctorProblem{Kind: problemStaleRecord, ID: bs.ID, Path: path.Join(pstatedir, "state.toml"),
+					Description: fmt.Sprintf("state records %s from %s which is not installed", bs.Name, bs.URL)})
+			}
+		}
+	} else {
+		log.Printf("WARNING: %s", err)
+	}
+
+	bindir := path.Join(contentdir, "bin")
+	if blist, err := ioutil.ReadDir(bindir); err == nil {
+		bundles, _ := cublib.GetBundles(contentdir)
+		for _, p := range blist {
+			// The launcher and its metadata aren't exported applications
+			if strings.HasPrefix(p.Name(), ".") {
+				continue
+			}
+			wrapper := path.Join(bindir, p.Name())
+			owner, app, err := cublib.GetExportOwner(contentdir, bundles, p.Name())
+			if err != nil {
+				problems = append(problems, DoctorProblem{Kind: problemDanglingExport, Path: wrapper,
+					Description: "wrapper is not exported by any installed bundle"})
+				continue
+			}
+			if _, err = os.Lstat(path.Join(owner.ChrootDir(contentdir), app)); err != nil {
+				problems = append(problems, DoctorProblem{Kind: problemDanglingExport, ID: owner.ID, Path: wrapper,
+					Description: fmt.Sprintf("wrapper target %s is missing", app)})
+				continue
+			}
+			if target, err := os.Readlink(wrapper); err != nil || target != cublib.LauncherName {
+				problems = append(problems, DoctorProblem{Kind: problemStaleExport, ID: owner.ID, Path: wrapper,
+					Description: "wrapper was generated by an older version"})
+			}
+		}
+	}
+	return problems
+}
+
+// fixProblem repairs p, returning whether exported applications need to be
+// regenerated afterwards.
+func fixProblem(statedir string, contentdir string, p *DoctorProblem) bool {
+	var err error
+	regenerate := false
+	switch p.Kind {
+	case problemOrphanContent, problemOrphanConfig, problemOrphanState:
+		err = removeBundleFiles(statedir, contentdir, p.ID)
+		regenerate = true
+	case problemBadConfig:
+		// The config shipped in the content is the best replacement available
+		b := cublib.Bundle{ID: p.ID}
+		var conf cublib.TomlConfig
+		if conf, err = cublib.GetUpdatedConfig(b.ChrootDir(contentdir)); err == nil {
+			err = cublib.WriteConfig(p.Path, conf, true)
+		}
+		regenerate = true
+	case problemLeftoverCert:
+		err = os.Remove(p.Path)
+	case problemStaleRecord:
+		err = removeRecord(statedir, p.ID)
+	case problemDanglingExport, problemStaleExport:
+		regenerate = true
+	default:
+		return false
+	}
+	if err != nil {
+		p.Error = err.Error()
+	} else {
+		p.Fixed = true
+	}
+	return regenerate
+}
+
+func Doctor(statedir string, contentdir string, settingsPath string, fix bool, skipPost bool, output string) {
+	// GetLock causes program exit on failure to acquire lockfile
+	cublib.GetLock(statedir)
+	defer cublib.ReleaseLock(statedir)
+	result := DoctorResult{Problems: diagnose(statedir, contentdir)}
+
+	if fix {
+		regenerate := false
+		for i := range result.Problems {
+			// An ID mismatch needs a decision on which bundle is wanted so it
+			// is only reported
+			if result.Problems[i].Kind == problemIDMismatch {
+				continue
+			}
+			if fixProblem(statedir, contentdir, &result.Problems[i]) {
+				regenerate = true
+			}
+		}
+		if regenerate && !skipPost {
+			if err := cublib.PostProcess(statedir, contentdir, settingsPath); err != nil {
+				log.Printf("WARNING: %s", err)
+				for i := range result.Problems {
+					if kind := result.Problems[i].Kind; kind == problemDanglingExport || kind == problemStaleExport {
+						result.Problems[i].Fixed = false
+						result.Problems[i].Error = err.Error()
+					}
+				}
+			}
+		} else if skipPost {
+			for i := range result.Problems {
+				if kind := result.Problems[i].Kind; kind == problemDanglingExport || kind == problemStaleExport {
+					result.Problems[i].Fixed = false
+				}
+			}
+		}
+	}
+
+	remaining := 0
+	for _, p := range result.Problems {
+		if !p.Fixed {
+			remaining++
+		}
+	}
+	printResult(output, result, func() {
+		if len(result.Problems) == 0 {
+			fmt.Println("No problems found")
+			return
+		}
+		for _, p := range result.Problems {
+			state := ""
+			if p.Fixed {
+				state = " (fixed)"
+			} else if p.Error != "" {
+				state = fmt.Sprintf(" (fix failed: %s)", p.Error)
+			}
+			fmt.Printf("%-18s %s: %s%s\n", p.Kind, p.Path, p.Description, state)
+		}
+	})
+	if remaining > 0 {
+		cublib.ReleaseLock(statedir)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operations
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+	"github.com/clearlinux/clr-user-bundles/cublib"
+)
+
+type FilesEntry struct {
+	Path    string `json:"path" toml:"path"`
+	Type    string `json:"type" toml:"type"`
+	Version string `json:"version" toml:"version"`
+	Hash    string `json:"hash" toml:"hash"`
+}
+
+type FilesResult struct {
+	ID      string       `json:"id" toml:"id"`
+	Name    string       `json:"name" toml:"name"`
+	Version string       `json:"version" toml:"version"`
+	Files   []FilesEntry `json:"files" toml:"file"`
+}
+
+type OwnsResult struct {
+	Path        string `json:"path" toml:"path"`
+	ID          string `json:"id" toml:"id"`
+	Name        string `json:"name" toml:"name"`
+	URL         string `json:"url" toml:"url"`
+	Version     string `json:"version" toml:"version"`
+	Hash        string `json:"hash" toml:"hash"`
+	ContentPath string `json:"content_path" toml:"content_path"`
+	Exported    bool   `json:"exported" toml:"exported"`
+}
+
+func fileType(f cublib.ManifestFile) string {
+	switch f.Type() {
+	case 'F':
+		return "file"
+	case 'D':
+		return "directory"
+	case 'L':
+		return "symlink"
+	}
+	return "unknown"
+}
+
+// installedManifest loads the manifest for the content currently installed for bundle.
+func installedManifest(statedir string, contentdir string, bundle cublib.Bundle) (cublib.Manifest, error) {
+	pchrootdir := bundle.ChrootDir(contentdir)
+	version, err := cublib.GetInstalledVersion(pchrootdir)
+	if err != nil {
+		return cublib.Manifest{}, fmt.Errorf("Unable to read installed version (%s): %s", pchrootdir, err)
+	}
+	m, err := cublib.GetInstalledManifest(bundle.StateDir(statedir), bundle.Config.Bundle.URL, version, bundle.Config.Bundle.Name)
+	if err != nil {
+		return cublib.Manifest{}, fmt.Errorf("Unable to load manifest for %s version %s: %s", bundle.Config.Bundle.Name, version, err)
+	}
+	return m, nil
+}
+
+func Files(statedir string, contentdir string, name string, output string) {
+	// GetLock causes program exit on failure to acquire lockfile
+	cublib.GetLock(statedir)
+	defer cublib.ReleaseLock(statedir)
+	bundle, err := cublib.FindBundle(contentdir, name)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	m, err := installedManifest(statedir, contentdir, bundle)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	result := FilesResult{ID: bundle.ID, Name: bundle.Config.Bundle.Name, Version: m.Version, Files: []FilesEntry{}}
+	for _, f := range m.Files {
+		if f.IsDeleted() {
+			continue
+		}
+		result.Files = append(result.Files, FilesEntry{Path: f.Path, Type: fileType(f), Version: f.Version, Hash: f.Hash})
+	}
+
+	printResult(output, result, func() {
+		for _, f := range result.Files {
+			fmt.Println(path.Join(bundle.ChrootDir(contentdir), f.Path))
+		}
+	})
+}
+
+func Owns(statedir string, contentdir string, target string, output string) {
+	// GetLock causes program exit on failure to acquire lockfile
+	cublib.GetLock(statedir)
+	defer cublib.ReleaseLock(statedir)
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		log.Fatalf("Unable to resolve %s: %s", target, err)
+	}
+	bundles, err := cublib.GetBundles(contentdir)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	result := OwnsResult{Path: abs}
+	var bundle cublib.Bundle
+	chrootdir := path.Join(contentdir, "chroot") + "/"
+	bindir := path.Join(contentdir, "bin") + "/"
+	switch {
+	case strings.HasPrefix(abs, chrootdir):
+		parts := strings.SplitN(strings.TrimPrefix(abs, chrootdir), "/", 2)
+		found := false
+		for _, b := range bundles {
+			if b.ID == parts[0] {
+				bundle = b
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Fatalf("%s is not part of an installed 3rd-party bundle", abs)
+		}
+		result.ContentPath = "/"
+		if len(parts) > 1 {
+			result.ContentPath = path.Join("/", parts[1])
+		}
+	case strings.HasPrefix(abs, bindir):
+		if bundle, result.ContentPath, err = cublib.GetExportOwner(contentdir, bundles, strings.TrimPrefix(abs, bindir)); err != nil {
+			log.Fatalf("%s is not exported by an installed 3rd-party bundle: %s", abs, err)
+		}
+		result.Exported = true
+	default:
+		log.Fatalf("%s is not under the 3rd-party content directory (%s)", abs, contentdir)
+	}
+	result.ID = bundle.ID
+	result.Name = bundle.Config.Bundle.Name
+	result.URL = bundle.Config.Bundle.URL
+
+	m, err := installedManifest(statedir, contentdir, bundle)
+	if err != nil {
+		log.Printf("WARNING: %s", err)
+	} else if f, ok := m.File(result.ContentPath); ok && !f.IsDeleted() {
+		result.Version = f.Version
+		result.Hash = f.Hash
+	} else {
+		log.Printf("WARNING: %s is not in the manifest of %s, it was not installed by swupd", result.ContentPath, result.Name)
+	}
+
+	printResult(output, result, func() {
+		fmt.Printf("Path:              %-28s\n", result.Path)
+		fmt.Printf("Name:              %-28s\n", result.Name)
+		fmt.Printf("ID:                %-28s\n", result.ID)
+		fmt.Printf("URL:               %-28s\n", result.URL)
+		fmt.Printf("Content path:      %-28s\n", result.ContentPath)
+		fmt.Printf("Version:           %-28s\n", orUnknown(result.Version))
+		fmt.Printf("Hash:              %-28s\n", orUnknown(result.Hash))
+	})
+}
@@ -0,0 +1,85 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operations
+
+import (
+	"fmt"
+	"log"
+	"os/user"
+	"strconv"
+	"strings"
+	"github.com/clearlinux/clr-user-bundles/cublib"
+)
+
+type HistoryResult struct {
+	Entries []cublib.HistoryEntry `json:"entries" toml:"entry"`
+}
+
+// recordHistory appends e to the history log. The operation has already
+// happened so failing to record it is only a warning.
+func recordHistory(statedir string, e cublib.HistoryEntry) {
+	if err := cublib.AppendHistory(statedir, e); err != nil {
+		log.Printf("WARNING: Unable to record %s in 3rd-party history: %s", e.Operation, err)
+	}
+}
+
+// historyUser describes who ran the operation, naming the user that invoked
+// sudo when there is one.
+func historyUser(e cublib.HistoryEntry) string {
+	name := strconv.Itoa(e.UID)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	if e.SudoUser != "" {
+		return fmt.Sprintf("%s (%s)", e.SudoUser, name)
+	}
+	return name
+}
+
+func History(statedir string, name string, output string) {
+	// Entries are appended with a single write, so while another operation
+	// holds the lock the log is still read, only without its entry
+	if err := cublib.GetSharedLock(statedir); err != nil {
+		log.Printf("WARNING: %s, the running operation is not listed yet", err)
+	}
+	defer cublib.ReleaseLock(statedir)
+	entries, err := cublib.ReadHistory(statedir)
+	if err != nil {
+		log.Fatalf("Unable to read 3rd-party history: %s", err)
+	}
+
+	result := HistoryResult{Entries: []cublib.HistoryEntry{}}
+	for _, e := range entries {
+		// Removed bundles are no longer installed so match the recorded
+		// name or ID rather than looking the bundle up
+		if name == "" || e.Name == name || e.ID == name {
+			result.Entries = append(result.Entries, e)
+		}
+	}
+
+	printResult(output, result, func() {
+		for _, e := range result.Entries {
+			versions := orDefault(e.ToVersion, e.FromVersion)
+			if e.FromVersion != "" && e.ToVersion != "" && e.FromVersion != e.ToVersion {
+				versions = fmt.Sprintf("%s -> %s", e.FromVersion, e.ToVersion)
+			}
+			fmt.Printf("%-25s %-16s %-12s %-20s %-12s %s\n", e.Time.Local().Format("2006-01-02 15:04:05 MST"),
+				historyUser(e), e.Operation, orDefault(e.Name, "-"), orDefault(versions, "-"), e.Result)
+			if e.Error != "" {
+				fmt.Printf("  %s\n", strings.Replace(strings.TrimSpace(e.Error), "\n", "\n  ", -1))
+			}
+		}
+	})
+}
@@ -0,0 +1,175 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operations
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"github.com/clearlinux/clr-user-bundles/cublib"
+)
+
+type InfoApplication struct {
+	Name    string `json:"name" toml:"name"`
+	Path    string `json:"path" toml:"path"`
+	Wrapper string `json:"wrapper" toml:"wrapper"`
+}
+
+type InfoSigner struct {
+	Subject        string `json:"subject" toml:"subject"`
+	Fingerprint    string `json:"fingerprint" toml:"fingerprint"`
+	NewFingerprint string `json:"new_fingerprint" toml:"new_fingerprint"`
+}
+
+type InfoResult struct {
+	ID               string            `json:"id" toml:"id"`
+	Name             string            `json:"name" toml:"name"`
+	Description      string            `json:"description" toml:"description"`
+	URL              string            `json:"url" toml:"url"`
+	InstalledVersion string            `json:"installed_version" toml:"installed_version"`
+	LatestVersion    string            `json:"latest_version" toml:"latest_version"`
+	Installed        string            `json:"installed" toml:"installed"`
+	Updated          string            `json:"updated" toml:"updated"`
+	LastAttempt      string            `json:"last_attempt" toml:"last_attempt"`
+	LastResult       string            `json:"last_result" toml:"last_result"`
+	Signer           InfoSigner        `json:"signer" toml:"signer"`
+	ContentSize      int64             `json:"content_size" toml:"content_size"`
+	StateSize        int64             `json:"state_size" toml:"state_size"`
+	Hold             bool              `json:"hold" toml:"hold"`
+	LastError        string            `json:"last_error" toml:"last_error"`
+	Includes         []string          `json:"includes" toml:"includes"`
+	Applications     []InfoApplication `json:"applications" toml:"application"`
+	Patterns         []cublib.BinMatch `json:"patterns" toml:"pattern"`
+}
+
+func Info(statedir string, contentdir string, name string, output string) {
+	// GetLock causes program exit on failure to acquire lockfile
+	cublib.GetLock(statedir)
+	defer cublib.ReleaseLock(statedir)
+	bundle, err := cublib.FindBundle(contentdir, name)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	pchrootdir := bundle.ChrootDir(contentdir)
+	pstatedir := bundle.StateDir(statedir)
+
+	installedVersion, err := cublib.GetInstalledVersion(pchrootdir)
+	if err != nil {
+		log.Printf("WARNING: Unable to read installed version (%s): %s", pchrootdir, err)
+	}
+	latestVersion, err := cublib.GetVersion(bundle.Config.Bundle.URL, pstatedir)
+	if err != nil {
+		log.Printf("WARNING: Unable to get latest version from uri (%s): %s", bundle.Config.Bundle.URL, err)
+	}
+	state, err := cublib.LoadState(statedir)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	bs := state.Track(bundle, statedir, contentdir)
+	cert, err := cublib.GetCertInfo(cublib.GetBundleCertPath(pchrootdir))
+	if err != nil {
+		log.Printf("WARNING: Unable to read signing certificate: %s", err)
+	}
+	signer := InfoSigner{Subject: cert.Subject, Fingerprint: bs.CertFingerprint}
+	// As diff and update do, report content signed with another certificate
+	// than the one recorded for the bundle
+	if cert.Fingerprint != "" && cert.Fingerprint != signer.Fingerprint {
+		signer.NewFingerprint = cert.Fingerprint
+	} else if latestVersion != "" && latestVersion != installedVersion {
+		certURI := bundle.Config.Bundle.URL + path.Join("/", latestVersion, "Swupd_Root.pem")
+		if newCert, err := cublib.GetRemoteCertInfo(certURI); err != nil {
+			log.Printf("WARNING: Unable to load certificate (%s): %s", certURI, err)
+		} else if newCert.Fingerprint != signer.Fingerprint {
+			signer.NewFingerprint = newCert.Fingerprint
+		}
+	}
+	contentSize, err := cublib.DirSize(pchrootdir)
+	if err != nil {
+		log.Printf("WARNING: Unable to get size of %s: %s", pchrootdir, err)
+	}
+	stateSize, err := cublib.DirSize(pstatedir)
+	if err != nil {
+		log.Printf("WARNING: Unable to get size of %s: %s", pstatedir, err)
+	}
+
+	result := InfoResult{
+		ID:               bundle.ID,
+		Name:             bundle.Config.Bundle.Name,
+		Description:      bundle.Config.Bundle.Description,
+		URL:              bundle.Config.Bundle.URL,
+		InstalledVersion: installedVersion,
+		LatestVersion:    latestVersion,
+		Installed:        formatTimestamp(bs.Installed),
+		Updated:          formatTimestamp(bs.Updated),
+		LastAttempt:      formatTimestamp(bs.LastAttempt),
+		LastResult:       bs.LastResult,
+		Signer:           signer,
+		ContentSize:      contentSize,
+		StateSize:        stateSize,
+		Hold:             bs.Hold,
+		LastError:        bs.LastError,
+		Includes:         emptyIfNil(bs.Includes),
+		Applications:     []InfoApplication{},
+	}
+	apps, matches := cublib.ResolveApps(bundle.ChrootDir(contentdir), bundle.Config.Bundle)
+	result.Patterns = matches
+	for _, app := range apps {
+		// Another bundle may export the command instead
+		wrapper := path.Join(contentdir, "bin", app.Name)
+		if owner, _, err := cublib.GetExportOwner(contentdir, []cublib.Bundle{bundle}, app.Name); err != nil || owner.ID != bundle.ID {
+			wrapper = ""
+		}
+		result.Applications = append(result.Applications, InfoApplication{Name: app.Name, Path: app.Target, Wrapper: wrapper})
+	}
+
+	printResult(output, result, func() {
+		fmt.Printf("Name:              %-28s\n", result.Name)
+		fmt.Printf("ID:                %-28s\n", result.ID)
+		fmt.Printf("Description:       %-28s\n", result.Description)
+		fmt.Printf("URL:               %-28s\n", result.URL)
+		fmt.Printf("Installed version: %-28s\n", orUnknown(result.InstalledVersion))
+		fmt.Printf("Latest version:    %-28s\n", orUnknown(result.LatestVersion))
+		fmt.Printf("Installed:         %-28s\n", formatTime(bs.Installed))
+		fmt.Printf("Updated:           %-28s\n", formatTime(bs.Updated))
+		fmt.Printf("Last attempt:      %-28s\n", formatTime(bs.LastAttempt))
+		fmt.Printf("Signer:            %-28s\n", orUnknown(result.Signer.Subject))
+		fmt.Printf("Fingerprint:       %-28s\n", orUnknown(result.Signer.Fingerprint))
+		if result.Signer.NewFingerprint != "" {
+			fmt.Printf("Signer changes:    %s -> %s\n", orUnknown(result.Signer.Fingerprint), result.Signer.NewFingerprint)
+		}
+		fmt.Printf("Content size:      %-28d\n", result.ContentSize)
+		fmt.Printf("State size:        %-28d\n", result.StateSize)
+		fmt.Printf("Hold:              %-28t\n", result.Hold)
+		if result.LastError != "" {
+			fmt.Printf("Last error:        %-28s\n", result.LastError)
+		}
+		if len(result.Includes) > 0 {
+			fmt.Printf("Host bundles:      %-28s\n", strings.Join(result.Includes, " "))
+		}
+		if len(result.Applications) > 0 {
+			fmt.Println("Applications:")
+			for _, app := range result.Applications {
+				fmt.Printf("                   %-28s %s\n", app.Path, orDefault(app.Wrapper, "(not exported)"))
+			}
+		}
+		if len(result.Patterns) > 0 {
+			fmt.Println("Patterns:")
+			for _, m := range result.Patterns {
+				fmt.Printf("                   %-28s %s\n", m.Pattern, orDefault(strings.Join(m.Matches, " "), "(no match)"))
+			}
+		}
+	})
+}
@@ -16,59 +16,126 @@ package operations
 
 import (
 	"fmt"
-	"io/ioutil"
 	"log"
-	"path"
-	"path/filepath"
+	"strings"
 	"github.com/clearlinux/clr-user-bundles/cublib"
 )
 
-func List(statedir string, contentdir string) {
+type ListCommand struct {
+	Name        string            `json:"name" toml:"name"`
+	Target      string            `json:"target" toml:"target"`
+	Args        []string          `json:"args" toml:"args"`
+	Env         map[string]string `json:"env" toml:"env"`
+	WorkDir     string            `json:"workdir" toml:"workdir"`
+	Description string            `json:"description" toml:"description"`
+}
+
+type ListBundle struct {
+	ID           string   `json:"id" toml:"id"`
+	Name         string   `json:"name" toml:"name"`
+	Description  string   `json:"description" toml:"description"`
+	URL          string   `json:"url" toml:"url"`
+	Version      string   `json:"version" toml:"version"`
+	Applications []string      `json:"applications" toml:"applications"`
+	Commands     []ListCommand `json:"commands" toml:"command"`
+	Includes     []string      `json:"includes" toml:"includes"`
+	Installed    string        `json:"installed" toml:"installed"`
+	LastAttempt  string        `json:"last_attempt" toml:"last_attempt"`
+	LastResult   string        `json:"last_result" toml:"last_result"`
+	Hold         bool          `json:"hold" toml:"hold"`
+	HostBundles  []string      `json:"host_bundles" toml:"host_bundles"`
+}
+
+type ListResult struct {
+	Bundles []ListBundle `json:"bundles" toml:"bundle"`
+}
+
+func List(statedir string, contentdir string, output string) {
 	// GetLock causes program exit on failure to acquire lockfile
 	cublib.GetLock(statedir)
 	defer cublib.ReleaseLock(statedir)
-	chrootdir := path.Join(contentdir, "chroot")
-	dlist, err := ioutil.ReadDir(chrootdir)
+	bundles, err := cublib.GetBundles(contentdir)
 	if err != nil {
-		log.Fatalf("Unable to read 3rd-party content directory (%s): %s", chrootdir, err)
+		log.Fatalf("%s", err)
 	}
 
-	fmt.Println("Installed 3rd-party bundles")
-	for _, p := range dlist {
-		// chroot dir should only be chroot directories and conf files so skip the conf files
-		// as it is easier to make those names from the directory names
-		if ext := filepath.Ext(p.Name()); ext != "" {
-			continue
-		}
-		confPath := "file://" + path.Join(chrootdir, p.Name()) + ".toml"
-		conf, err := cublib.GetConfig(confPath)
+	state, err := cublib.LoadState(statedir)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	result := ListResult{Bundles: []ListBundle{}}
+	for _, b := range bundles {
+		// Includes can be updated by the 3rd-party repo so show the updated config in that case
+		pchrootdir := b.ChrootDir(contentdir)
+		newConf, err := cublib.GetUpdatedConfig(pchrootdir)
 		if err != nil {
-			log.Printf("WARNING: Unable to read 3rd-party config (%s): %s", confPath, err)
+			log.Printf("WARNING: Unable to read updated 3rd-party config (%s): %s", pchrootdir, err)
 			continue
 		}
-		// Includes can be updated by the 3rd-party repo so show the updated config in that case
-		newConfPath := "file://" + path.Join(chrootdir, p.Name(), "usr", "user-config.toml")
-		newConf, err := cublib.GetConfig(newConfPath)
+		version, err := cublib.GetInstalledVersion(pchrootdir)
 		if err != nil {
-			log.Printf("WARNING: Unable to read updated 3rd-party config (%s): %s", newConfPath, err)
-			continue
+			log.Printf("WARNING: Unable to read installed version (%s): %s", pchrootdir, err)
 		}
-		fmt.Println("")
-		fmt.Println("Included Bundles:")
-		fmt.Printf("Name:              %-28s\n", conf.Bundle.Name)
-		fmt.Printf("Description:       %-28s\n", conf.Bundle.Description)
-		fmt.Printf("URL:               %-28s\n", conf.Bundle.URL)
-		if len(conf.Bundle.Bin) > 0 {
-			fmt.Println("Applications:")
-			for _, app := range conf.Bundle.Bin {
-				fmt.Printf("                   %-28s\n", app)
+		bs := state.Track(b, statedir, contentdir)
+		applications := []string{}
+		commands := []ListCommand{}
+		apps, _ := cublib.ResolveApps(pchrootdir, b.Config.Bundle)
+		for _, app := range apps {
+			applications = append(applications, app.Target)
+			env := app.Env
+			if env == nil {
+				env = map[string]string{}
 			}
+			commands = append(commands, ListCommand{
+				Name:        app.Name,
+				Target:      app.Target,
+				Args:        emptyIfNil(app.Args),
+				Env:         env,
+				WorkDir:     app.WorkDir,
+				Description: app.Description,
+			})
 		}
-		if len(newConf.Bundle.Includes) > 0 {
+		result.Bundles = append(result.Bundles, ListBundle{
+			ID:           b.ID,
+			Name:         b.Config.Bundle.Name,
+			Description:  b.Config.Bundle.Description,
+			URL:          b.Config.Bundle.URL,
+			Version:      version,
+			Applications: applications,
+			Commands:     commands,
+			Includes:     append([]string{}, newConf.Bundle.Includes...),
+			Installed:    formatTimestamp(bs.Installed),
+			LastAttempt:  formatTimestamp(bs.LastAttempt),
+			LastResult:   bs.LastResult,
+			Hold:         bs.Hold,
+			HostBundles:  append([]string{}, bs.Includes...),
+		})
+	}
+
+	printResult(output, result, func() {
+		fmt.Println("Installed 3rd-party bundles")
+		for _, b := range result.Bundles {
+			fmt.Println("")
 			fmt.Println("Included Bundles:")
-			for _, include := range newConf.Bundle.Includes {
-				fmt.Printf("                   %-28s\n", include)
+			fmt.Printf("Name:              %-28s\n", b.Name)
+			fmt.Printf("Description:       %-28s\n", b.Description)
+			fmt.Printf("URL:               %-28s\n", b.URL)
+			if len(b.Commands) > 0 {
+				fmt.Println("Applications:")
+				for _, c := range b.Commands {
+					fmt.Printf("                   %-28s %s\n", c.Name, strings.Join(append([]string{c.Target}, c.Args...), " "))
+					if c.Description != "" {
+						fmt.Printf("                     %s\n", c.Description)
+					}
+				}
+			}
+			if len(b.Includes) > 0 {
+				fmt.Println("Included Bundles:")
+				for _, include := range b.Includes {
+					fmt.Printf("                   %-28s\n", include)
+				}
 			}
 		}
-	}
+	})
 }
@@ -0,0 +1,112 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operations
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+	"github.com/BurntSushi/toml"
+)
+
+// Output formats accepted by the --output flag. The json and toml schemas are
+// documented in swupd-3rd-party(1) and fields must only ever be added to them.
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputTOML  = "toml"
+)
+
+func ValidOutput(format string) error {
+	switch format {
+	case OutputTable, OutputJSON, OutputTOML:
+		return nil
+	}
+	return fmt.Errorf("Invalid output format %s, must be one of table, json or toml", format)
+}
+
+// printResult writes result to stdout in the machine readable format requested
+// or calls table to print the human readable form.
+func printResult(format string, result interface{}, table func()) {
+	var err error
+	switch format {
+	case OutputJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(result)
+	case OutputTOML:
+		err = toml.NewEncoder(os.Stdout).Encode(result)
+	default:
+		table()
+	}
+	if err != nil {
+		log.Fatalf("Unable to write %s output: %s", format, err)
+	}
+}
+
+// formatTimestamp renders times for the machine readable output, unknown
+// times are an empty string.
+func formatTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func orDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func orUnknown(value string) string {
+	return orDefault(value, "unknown")
+}
+
+// formatTime renders times for the table output.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format(time.RFC1123)
+}
+
+// formatSize renders byte counts with binary units for the table output.
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size) / 1024
+	units := "KiB"
+	for _, next := range []string{"MiB", "GiB", "TiB"} {
+		if value < 1024 {
+			break
+		}
+		value /= 1024
+		units = next
+	}
+	return fmt.Sprintf("%.1f %s", value, units)
+}
+
+// emptyIfNil keeps lists in machine readable output from being null.
+func emptyIfNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
@@ -15,13 +15,17 @@
 package operations
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path"
 	"github.com/clearlinux/clr-user-bundles/cublib"
 )
 
-func Remove(statedir string, contentdir string, uri string, name string, skipPost bool, lock bool) {
+// Remove deletes the bundle content, state and config. Without lock it is
+// cleaning up after a failed add, which records its own history, so errors
+// are returned for it to report instead of exiting.
+func Remove(statedir string, contentdir string, settingsPath string, uri string, name string, skipPost bool, lock bool) error {
 	if lock {
 		// GetLock causes program exit on failure to acquire lockfile
 		cublib.GetLock(statedir)
@@ -30,6 +34,12 @@ func Remove(statedir string, contentdir string, uri string, name string, skipPos
 	encodedName := cublib.GetEncodedBundleName(uri, name)
 	pstatedir := path.Join(statedir, "3rd-party", encodedName)
 	chrootdir := path.Join(contentdir, "chroot", encodedName)
+	entry := cublib.NewHistoryEntry(cublib.OpRemove)
+	entry.ID = encodedName
+	entry.Name = name
+	entry.URL = uri
+	entry.FromVersion, _ = cublib.GetInstalledVersion(chrootdir)
+	cublib.ReleaseUnits(contentdir, encodedName)
 	err := os.RemoveAll(pstatedir)
 	if err != nil {
 		log.Printf("WARNING: Unable to remove 3rd-party state directory (%s): %s", pstatedir, err)
@@ -42,10 +52,76 @@ func Remove(statedir string, contentdir string, uri string, name string, skipPos
 	if err != nil {
 		log.Printf("WARNING: Unable to remove 3rd-party config (%s): %s", chrootdir + ".toml", err)
 	}
-	if skipPost {
-		return
+	if state, err := cublib.LoadState(statedir); err != nil {
+		log.Printf("WARNING: %s", err)
+	} else if state.Bundle(encodedName) != nil {
+		state.Remove(encodedName)
+		if err = state.Save(statedir); err != nil {
+			log.Printf("WARNING: Unable to save 3rd-party state: %s", err)
+		}
 	}
-	if err = cublib.PostProcess(statedir, contentdir); err != nil {
+	err = nil
+	if !skipPost {
+		err = cublib.PostProcess(statedir, contentdir, settingsPath)
+	}
+	if !lock {
+		return err
+	}
+	entry.Finish(err)
+	recordHistory(statedir, entry)
+	if err != nil {
 		log.Fatalf("%s", err)
 	}
+	return nil
+}
+
+type RemovePlan struct {
+	ID          string   `json:"id" toml:"id"`
+	Directories []string `json:"directories" toml:"directories"`
+	Files       []string `json:"files" toml:"files"`
+	Wrappers    []string `json:"wrappers" toml:"wrappers"`
+	Units       []string `json:"units" toml:"units"`
+}
+
+// RemoveDryRun lists what Remove would delete for the bundle without deleting it.
+func RemoveDryRun(statedir string, contentdir string, uri string, name string, output string) {
+	// GetLock causes program exit on failure to acquire lockfile
+	cublib.GetLock(statedir)
+	defer cublib.ReleaseLock(statedir)
+	bundle := cublib.Bundle{ID: cublib.GetEncodedBundleName(uri, name)}
+	plan := RemovePlan{ID: bundle.ID, Directories: []string{}, Files: []string{}, Wrappers: []string{}, Units: []string{}}
+	for _, dir := range []string{bundle.StateDir(statedir), bundle.ChrootDir(contentdir)} {
+		if _, err := os.Lstat(dir); err == nil {
+			plan.Directories = append(plan.Directories, dir)
+		}
+	}
+	configPath := bundle.ConfigPath(contentdir)
+	if _, err := os.Lstat(configPath); err == nil {
+		plan.Files = append(plan.Files, configPath)
+	}
+	if len(plan.Directories) == 0 && len(plan.Files) == 0 {
+		log.Fatalf("Bundle %s from %s is not installed", name, uri)
+	}
+	if conf, err := cublib.GetConfig("file://" + configPath); err == nil {
+		bundle.Config = conf
+		apps, _ := cublib.ResolveApps(bundle.ChrootDir(contentdir), conf.Bundle)
+		for _, app := range apps {
+			if owner, _, err := cublib.GetExportOwner(contentdir, []cublib.Bundle{bundle}, app.Name); err == nil && owner.ID == bundle.ID {
+				plan.Wrappers = append(plan.Wrappers, path.Join(contentdir, "bin", app.Name))
+			}
+		}
+		for _, unit := range conf.Bundle.Units {
+			if cublib.UnitOwner(contentdir, unit) == bundle.ID {
+				plan.Units = append(plan.Units, path.Join(cublib.UnitDir(contentdir), unit))
+			}
+		}
+	}
+
+	printResult(output, plan, func() {
+		for _, list := range [][]string{plan.Directories, plan.Files, plan.Wrappers, plan.Units} {
+			for _, item := range list {
+				fmt.Println(item)
+			}
+		}
+	})
 }
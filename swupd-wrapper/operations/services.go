@@ -0,0 +1,71 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operations
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"github.com/clearlinux/clr-user-bundles/cublib"
+)
+
+type ServiceUnit struct {
+	Name     string `json:"name" toml:"name"`
+	Source   string `json:"source" toml:"source"`
+	Path     string `json:"path" toml:"path"`
+	Exported bool   `json:"exported" toml:"exported"`
+	Enabled  string `json:"enabled" toml:"enabled"`
+	Active   string `json:"active" toml:"active"`
+}
+
+type ServicesResult struct {
+	ID    string        `json:"id" toml:"id"`
+	Name  string        `json:"name" toml:"name"`
+	Units []ServiceUnit `json:"units" toml:"unit"`
+}
+
+func Services(statedir string, contentdir string, name string, output string) {
+	// GetLock causes program exit on failure to acquire lockfile
+	cublib.GetLock(statedir)
+	defer cublib.ReleaseLock(statedir)
+	bundle, err := cublib.FindBundle(contentdir, name)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	result := ServicesResult{ID: bundle.ID, Name: bundle.Config.Bundle.Name, Units: []ServiceUnit{}}
+	for _, unit := range bundle.Config.Bundle.Units {
+		entry := ServiceUnit{
+			Name:   unit,
+			Source: path.Join(bundle.ChrootDir(contentdir), "usr/lib/systemd/system", unit),
+		}
+		if cublib.UnitOwner(contentdir, unit) == bundle.ID {
+			entry.Exported = true
+			entry.Path = path.Join(cublib.UnitDir(contentdir), unit)
+			entry.Enabled, entry.Active = cublib.UnitStatus(unit)
+		}
+		result.Units = append(result.Units, entry)
+	}
+
+	printResult(output, result, func() {
+		for _, u := range result.Units {
+			if !u.Exported {
+				fmt.Printf("%-32s (not exported)\n", u.Name)
+				continue
+			}
+			fmt.Printf("%-32s %-10s %-10s %s\n", u.Name, u.Enabled, u.Active, u.Path)
+		}
+	})
+}
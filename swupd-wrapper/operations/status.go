@@ -0,0 +1,173 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operations
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"github.com/clearlinux/clr-user-bundles/cublib"
+)
+
+// Health verdicts reported by Status, ordered from best to worst. Their index
+// is the exit status of Status.
+var healthLevels = []string{"ok", "warning", "critical"}
+
+// healthUnknown is the exit status when the state can't be read at all.
+const healthUnknown = 3
+
+type StatusBundle struct {
+	ID                  string   `json:"id" toml:"id"`
+	Name                string   `json:"name" toml:"name"`
+	URL                 string   `json:"url" toml:"url"`
+	InstalledVersion    string   `json:"installed_version" toml:"installed_version"`
+	LatestVersion       string   `json:"latest_version" toml:"latest_version"`
+	LastChecked         string   `json:"last_checked" toml:"last_checked"`
+	UpdatePending       bool     `json:"update_pending" toml:"update_pending"`
+	Hold                bool     `json:"hold" toml:"hold"`
+	LastSuccess         string   `json:"last_success" toml:"last_success"`
+	LastFailure         string   `json:"last_failure" toml:"last_failure"`
+	LastError           string   `json:"last_error" toml:"last_error"`
+	ConsecutiveFailures int      `json:"consecutive_failures" toml:"consecutive_failures"`
+	NextAttempt         string   `json:"next_attempt" toml:"next_attempt"`
+	Health              string   `json:"health" toml:"health"`
+	Reasons             []string `json:"reasons" toml:"reasons"`
+}
+
+type StatusResult struct {
+	Health  string         `json:"health" toml:"health"`
+	Bundles []StatusBundle `json:"bundles" toml:"bundle"`
+}
+
+// bundleHealth judges how automatic updates of a bundle are doing, returning
+// the index of the verdict in healthLevels and the reasons for it.
+func bundleHealth(bs *cublib.BundleState, pending bool, maxFailures int, maxAge time.Duration) (int, []string) {
+	level := 0
+	reasons := []string{}
+	worse := func(l int, reason string) {
+		if l > level {
+			level = l
+		}
+		reasons = append(reasons, reason)
+	}
+	if bs.Hold {
+		return level, append(reasons, "held")
+	}
+	if bs.Failures >= maxFailures {
+		worse(2, fmt.Sprintf("%d consecutive update failures", bs.Failures))
+	} else if bs.Failures > 0 {
+		worse(1, fmt.Sprintf("%d consecutive update failure(s)", bs.Failures))
+	}
+	if maxAge > 0 {
+		if bs.LastSuccess.IsZero() {
+			worse(2, "no successful update recorded")
+		} else if age := time.Since(bs.LastSuccess); age > maxAge {
+			worse(2, fmt.Sprintf("last successful update %s ago", age.Truncate(time.Minute)))
+		}
+	}
+	if pending && time.Now().Before(bs.DeferredUntil) {
+		reasons = append(reasons, fmt.Sprintf("update deferred until %s", bs.DeferredUntil.Format(time.RFC1123)))
+	} else if pending {
+		worse(1, "update pending")
+	}
+	return level, reasons
+}
+
+func Status(statedir string, contentdir string, maxFailures int, maxAge time.Duration, output string) {
+	// Only reads the state so don't hold up the operations changing it, but
+	// the state isn't known while one of them is running
+	if err := cublib.GetSharedLock(statedir); err != nil {
+		log.Printf("%s", err)
+		os.Exit(healthUnknown)
+	}
+	defer cublib.ReleaseLock(statedir)
+	bundles, err := cublib.GetBundles(contentdir)
+	if err != nil {
+		log.Printf("%s", err)
+		cublib.ReleaseLock(statedir)
+		os.Exit(healthUnknown)
+	}
+	state, err := cublib.LoadState(statedir)
+	if err != nil {
+		log.Printf("%s", err)
+		cublib.ReleaseLock(statedir)
+		os.Exit(healthUnknown)
+	}
+
+	overall := 0
+	result := StatusResult{Bundles: []StatusBundle{}}
+	for _, b := range bundles {
+		bs := state.Track(b, statedir, contentdir)
+		installed, err := cublib.GetInstalledVersion(b.ChrootDir(contentdir))
+		if err != nil {
+			log.Printf("WARNING: Unable to read installed version (%s): %s", b.ChrootDir(contentdir), err)
+		}
+		pending := newerVersion(installed, bs.LatestVersion)
+		level, reasons := bundleHealth(bs, pending, maxFailures, maxAge)
+		if level > overall {
+			overall = level
+		}
+		result.Bundles = append(result.Bundles, StatusBundle{
+			ID:                  b.ID,
+			Name:                b.Config.Bundle.Name,
+			URL:                 b.Config.Bundle.URL,
+			InstalledVersion:    installed,
+			LatestVersion:       bs.LatestVersion,
+			LastChecked:         formatTimestamp(bs.LastChecked),
+			UpdatePending:       pending,
+			Hold:                bs.Hold,
+			LastSuccess:         formatTimestamp(bs.LastSuccess),
+			LastFailure:         formatTimestamp(bs.LastFailure),
+			LastError:           bs.LastError,
+			ConsecutiveFailures: bs.Failures,
+			NextAttempt:         formatTimestamp(bs.NextAttempt()),
+			Health:              healthLevels[level],
+			Reasons:             reasons,
+		})
+	}
+	result.Health = healthLevels[overall]
+
+	printResult(output, result, func() {
+		for _, b := range result.Bundles {
+			bs := state.Bundle(b.ID)
+			fmt.Printf("%-28s %s\n", b.Name, b.Health)
+			latest := orUnknown(b.LatestVersion)
+			if !bs.LastChecked.IsZero() {
+				latest = fmt.Sprintf("%s (checked %s)", latest, formatTime(bs.LastChecked))
+			}
+			fmt.Printf("  Installed version:    %s\n", orUnknown(b.InstalledVersion))
+			fmt.Printf("  Latest version:       %s\n", latest)
+			fmt.Printf("  Last success:         %s\n", formatTime(bs.LastSuccess))
+			fmt.Printf("  Last failure:         %s\n", formatTime(bs.LastFailure))
+			fmt.Printf("  Consecutive failures: %d\n", b.ConsecutiveFailures)
+			if b.NextAttempt != "" {
+				fmt.Printf("  Next scheduled try:   %s\n", formatTime(bs.NextAttempt()))
+			}
+			if b.LastError != "" {
+				fmt.Printf("  Last error:           %s\n", strings.TrimSpace(b.LastError))
+			}
+			if len(b.Reasons) > 0 {
+				fmt.Printf("  Reasons:              %s\n", strings.Join(b.Reasons, ", "))
+			}
+		}
+		fmt.Printf("Health: %s\n", result.Health)
+	})
+	if overall > 0 {
+		cublib.ReleaseLock(statedir)
+		os.Exit(overall)
+	}
+}
@@ -23,29 +23,60 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"time"
 	"github.com/clearlinux/clr-user-bundles/cublib"
 )
 
-func updateContent(statedir string, contentdir string, config cublib.TomlConfig) error {
+// updateTarget picks the version to update bundle to given its deferral
+// policy: the newest version published at least deferFor ago. until is when
+// the latest version becomes eligible, zero when it isn't deferred.
+func updateTarget(bundle cublib.Bundle, pstatedir string, installed string, deferFor time.Duration, now time.Time) (target string, latest string, until time.Time, err error) {
+	uri := bundle.Config.Bundle.URL
+	if latest, err = cublib.GetVersion(uri, pstatedir); err != nil {
+		return "", "", until, fmt.Errorf("Unable to get latest version from uri (%s): %s", uri, err)
+	}
+	if deferFor == 0 {
+		return latest, latest, until, nil
+	}
+	if target, until, err = cublib.EligibleVersion(uri, installed, latest, deferFor, now); err != nil {
+		return "", latest, until, fmt.Errorf("Unable to apply deferral policy: %s", err)
+	}
+	return target, latest, until, nil
+}
+
+// updateContent updates the bundle content to version, or the latest version
+// when it is empty, and installs its includes, returning the includes that
+// weren't already on the host.
+func updateContent(statedir string, contentdir string, config cublib.TomlConfig, version string) ([]string, error) {
 	// -b and -N are essential, scripts are security dangerous since 3rd party content would get to run as root
 	format, err := cublib.GetFormat()
 	if err != nil {
-		return err;
+		return nil, err
 	}
  	certPath := path.Join(contentdir, "/usr/share/clear/update-ca/Swupd_Root.pem")
 	var cmd *exec.Cmd
 	var out bytes.Buffer
-	cmd = exec.Command("swupd", "update", "-b", "-N", "-F", format, "-S", statedir, "-p", contentdir, "-u", config.Bundle.URL, "-C", certPath)
+	args := []string{"update", "-b", "-N", "-F", format, "-S", statedir, "-p", contentdir, "-u", config.Bundle.URL, "-C", certPath}
+	if version != "" {
+		args = append(args, "-m", version)
+	}
+	cmd = exec.Command("swupd", args...)
 	cmd.Stdout = &out
 	cmd.Stderr = &out
 	err = cmd.Run()
 	if err != nil {
-		return errors.New(out.String())
+		return nil, errors.New(out.String())
 	}
 	newConfPath := "file://" + path.Join(contentdir, "usr", "user-config.toml")
 	newConfig, err := cublib.GetConfig(newConfPath)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Couldn't load new 3rd-party config: %s", err))
+		return nil, errors.New(fmt.Sprintf("Couldn't load new 3rd-party config: %s", err))
+	}
+	var added []string
+	for _, include := range newConfig.Bundle.Includes {
+		if !cublib.IsHostBundleInstalled(include) {
+			added = append(added, include)
+		}
 	}
 	if len(newConfig.Bundle.Includes) > 0 {
 		cmd = exec.Command("swupd", append([]string{"bundle-add"}, newConfig.Bundle.Includes...)...)
@@ -54,15 +85,14 @@ func updateContent(statedir string, contentdir string, config cublib.TomlConfig)
 		cmd.Stderr = &out
 		err = cmd.Run()
 		if err != nil {
-			return errors.New(fmt.Sprintf("Unable to install dependency bundle(s) %s to the base system: %s", newConfig.Bundle.Includes, out.String()))
+			return nil, errors.New(fmt.Sprintf("Unable to install dependency bundle(s) %s to the base system: %s", newConfig.Bundle.Includes, out.String()))
 		}
 	}
 
-
-	return nil
+	return added, nil
 }
 
-func Update(statedir string, contentdir string, skipPost bool) {
+func Update(statedir string, contentdir string, settingsPath string, skipPost bool, scheduled bool) {
 	// GetLock causes program exit on failure to acquire lockfile
 	cublib.GetLock(statedir)
 	defer cublib.ReleaseLock(statedir)
@@ -72,6 +102,20 @@ func Update(statedir string, contentdir string, skipPost bool) {
 	if err != nil {
 		log.Fatalf("Unable to read 3rd-party content directory (%s): %s", chrootdir, err)
 	}
+	state, err := cublib.LoadState(statedir)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	settings, err := cublib.LoadSettings(settingsPath)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	// Outside of the maintenance windows scheduled updates wait for the next
+	// run, this isn't a failure
+	if now := time.Now(); scheduled && !settings.InWindow(now) {
+		fmt.Printf("deferred: outside of maintenance windows, the next one opens %s\n", settings.NextWindow(now).Format(time.RFC1123))
+		return
+	}
 
 	for _, p := range dlist {
 		// chroot dir should only be chroot directories and conf files so skip the conf files
@@ -85,16 +129,83 @@ func Update(statedir string, contentdir string, skipPost bool) {
 			log.Printf("WARNING: Unable to read 3rd party config (%s): %s", confPath, err)
 			continue
 		}
-		// NOTE: content chroot exists but matching config doesn't => warning
-		// BUT content chroot doesn't exist and config does => ignored, manual cleanup required
-		if err = updateContent(path.Join(pstatedir, p.Name()), path.Join(chrootdir, p.Name()), conf); err != nil {
+		bstatedir := path.Join(pstatedir, p.Name())
+		bchrootdir := path.Join(chrootdir, p.Name())
+		bundle := cublib.Bundle{ID: p.Name(), Config: conf}
+		bs := state.Track(bundle, statedir, contentdir)
+		if bs.Hold {
+			log.Printf("Skipping held bundle (%s %s)", conf.Bundle.URL, conf.Bundle.Name)
+			continue
+		}
+		now := time.Now()
+		// Unattended runs leave failing repos alone for a while instead of
+		// retrying them every time, manual runs always try
+		if next := bs.NextAttempt(); scheduled && now.Before(next) {
+			log.Printf("Skipping (%s %s) until %s after %d consecutive failure(s)", conf.Bundle.URL, conf.Bundle.Name, next.Format(time.RFC1123), bs.Failures)
+			continue
+		}
+		oldVersion, _ := cublib.GetInstalledVersion(bchrootdir)
+		// Without a deferral policy swupd picks the latest version itself
+		target, latest := "", ""
+		bs.DeferredUntil = time.Time{}
+		if deferFor := settings.DeferFor(bundle); deferFor > 0 {
+			target, latest, bs.DeferredUntil, err = updateTarget(bundle, bstatedir, oldVersion, deferFor, now)
+			if err == nil && latest != "" {
+				bs.RecordLatest(now, latest)
+			}
+			if err == nil && target == oldVersion && !bs.DeferredUntil.IsZero() {
+				log.Printf("Deferring update of (%s %s) to version %s until %s", conf.Bundle.URL, conf.Bundle.Name, latest, bs.DeferredUntil.Format(time.RFC1123))
+				continue
+			}
+		}
+		entry := cublib.NewHistoryEntry(cublib.OpUpdate)
+		entry.ID = p.Name()
+		entry.Name = conf.Bundle.Name
+		entry.URL = conf.Bundle.URL
+		entry.FromVersion = oldVersion
+		var added []string
+		if err == nil {
+			// NOTE: content chroot exists but matching config doesn't => warning
+			// BUT content chroot doesn't exist and config does => ignored, doctor --fix cleans it up
+			added, err = updateContent(bstatedir, bchrootdir, conf, target)
+		}
+		entry.ToVersion, _ = cublib.GetInstalledVersion(bchrootdir)
+		entry.Finish(err)
+		// Runs that found the bundle up to date aren't worth recording
+		if err != nil || entry.ToVersion != oldVersion {
+			recordHistory(statedir, entry)
+		}
+		bs.RecordAttempt(now, err)
+		if err != nil {
 			log.Printf("WARNING: Unable to update (%s %s): %s", conf.Bundle.URL, conf.Bundle.Name, err)
+			// Keep track of what is pending for status
+			if latest == "" {
+				if latest, err = cublib.GetVersion(conf.Bundle.URL, bstatedir); err == nil {
+					bs.RecordLatest(now, latest)
+				}
+			}
+			continue
+		}
+		if latest == "" {
+			bs.RecordLatest(now, entry.ToVersion)
 		}
+		bs.AddIncludes(added...)
+		if entry.ToVersion != oldVersion {
+			bs.InstalledVersion = entry.ToVersion
+			bs.Updated = now
+		}
+		if cert, err := cublib.GetCertInfo(cublib.GetBundleCertPath(bchrootdir)); err == nil && cert.Fingerprint != bs.CertFingerprint {
+			log.Printf("WARNING: Signing certificate of (%s %s) changed from %s to %s (%s)", conf.Bundle.URL, conf.Bundle.Name, bs.CertFingerprint, cert.Fingerprint, cert.Subject)
+			bs.CertFingerprint = cert.Fingerprint
+		}
+	}
+	if err = state.Save(statedir); err != nil {
+		log.Printf("WARNING: Unable to save 3rd-party state: %s", err)
 	}
 	if skipPost {
 		return
 	}
-	if err = cublib.PostProcess(statedir, contentdir); err != nil {
+	if err = cublib.PostProcess(statedir, contentdir, settingsPath); err != nil {
 		log.Fatalf("%s", err)
 	}
 }
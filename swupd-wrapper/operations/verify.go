@@ -0,0 +1,167 @@
+// Copyright © 2019 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operations
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"github.com/clearlinux/clr-user-bundles/cublib"
+)
+
+type VerifyFile struct {
+	Path   string `json:"path" toml:"path"`
+	Status string `json:"status" toml:"status"`
+	Fixed  bool   `json:"fixed" toml:"fixed"`
+}
+
+type VerifyBundle struct {
+	ID      string       `json:"id" toml:"id"`
+	Name    string       `json:"name" toml:"name"`
+	Version string       `json:"version" toml:"version"`
+	Files   []VerifyFile `json:"files" toml:"file"`
+	Error   string       `json:"error" toml:"error"`
+}
+
+type VerifyResult struct {
+	Bundles []VerifyBundle `json:"bundles" toml:"bundle"`
+}
+
+// parseVerifyOutput collects the per file results reported by swupd verify.
+func parseVerifyOutput(out string, pchrootdir string) []VerifyFile {
+	files := []VerifyFile{}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		status := ""
+		var fpath string
+		switch {
+		case strings.HasPrefix(line, "Hash mismatch for file:"):
+			status = "modified"
+			fpath = strings.TrimPrefix(line, "Hash mismatch for file:")
+		case strings.HasPrefix(line, "Missing file:"):
+			status = "missing"
+			fpath = strings.TrimPrefix(line, "Missing file:")
+		case strings.HasPrefix(line, "-> fixed") && len(files) > 0:
+			files[len(files)-1].Fixed = true
+			continue
+		default:
+			continue
+		}
+		fpath = strings.TrimSpace(fpath)
+		if strings.HasPrefix(fpath, pchrootdir) {
+			fpath = path.Join("/", strings.TrimPrefix(fpath, pchrootdir))
+		}
+		files = append(files, VerifyFile{Path: fpath, Status: status})
+	}
+	return files
+}
+
+// verifyBundle runs swupd verify on the bundle chroot against the installed
+// version, restoring modified and missing files when fix is set.
+func verifyBundle(statedir string, contentdir string, bundle cublib.Bundle, fix bool) VerifyBundle {
+	pchrootdir := bundle.ChrootDir(contentdir)
+	result := VerifyBundle{ID: bundle.ID, Name: bundle.Config.Bundle.Name, Files: []VerifyFile{}}
+	format, err := cublib.GetFormat()
+	if err != nil {
+		result.Error = fmt.Sprintf("Unable to get format from filesystem: %s", err)
+		return result
+	}
+	if result.Version, err = cublib.GetInstalledVersion(pchrootdir); err != nil {
+		result.Error = fmt.Sprintf("Unable to read installed version (%s): %s", pchrootdir, err)
+		return result
+	}
+	// -b and -N are essential, scripts are security dangerous since 3rd party content would get to run as root
+	args := []string{"verify", "-b", "-N", "-S", bundle.StateDir(statedir), "-p", pchrootdir, "-u", bundle.Config.Bundle.URL,
+		"-F", format, "-m", result.Version, "-C", cublib.GetBundleCertPath(pchrootdir)}
+	if fix {
+		args = append(args, "-f")
+	}
+	cmd := exec.Command("swupd", args...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = cmd.Run()
+	result.Files = parseVerifyOutput(out.String(), pchrootdir)
+	// swupd verify exits with an error when it finds problems, only treat it
+	// as a failure to verify when there is nothing to report
+	if err != nil && len(result.Files) == 0 {
+		result.Error = strings.TrimSpace(out.String())
+	}
+	return result
+}
+
+func Verify(statedir string, contentdir string, name string, fix bool, output string) {
+	// GetLock causes program exit on failure to acquire lockfile
+	cublib.GetLock(statedir)
+	defer cublib.ReleaseLock(statedir)
+	var bundles []cublib.Bundle
+	if name != "" {
+		bundle, err := cublib.FindBundle(contentdir, name)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		bundles = append(bundles, bundle)
+	} else {
+		var err error
+		if bundles, err = cublib.GetBundles(contentdir); err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+
+	result := VerifyResult{Bundles: []VerifyBundle{}}
+	failed := false
+	for _, b := range bundles {
+		v := verifyBundle(statedir, contentdir, b, fix)
+		if v.Error != "" {
+			log.Printf("WARNING: Unable to verify (%s %s): %s", b.Config.Bundle.URL, b.Config.Bundle.Name, v.Error)
+			failed = true
+		}
+		for _, f := range v.Files {
+			if !f.Fixed {
+				failed = true
+			}
+		}
+		result.Bundles = append(result.Bundles, v)
+	}
+
+	printResult(output, result, func() {
+		for _, b := range result.Bundles {
+			state := "ok"
+			if b.Error != "" {
+				state = "verify failed"
+			} else if len(b.Files) > 0 {
+				state = fmt.Sprintf("%d problem(s)", len(b.Files))
+			}
+			fmt.Printf("%-28s %-8s %s\n", b.Name, orUnknown(b.Version), state)
+			for _, f := range b.Files {
+				fixed := ""
+				if f.Fixed {
+					fixed = " (fixed)"
+				}
+				fmt.Printf("  %-8s %s%s\n", f.Status, f.Path, fixed)
+			}
+		}
+	})
+	if failed {
+		cublib.ReleaseLock(statedir)
+		os.Exit(1)
+	}
+}
@@ -18,7 +18,7 @@ BASEDIR="$(pwd)"
 TESTDIR="$1"
 
 cleanup() {
-    sudo rm -fr c c2 s o test.toml privatekey.pem Swupd_Root.pem
+    sudo rm -fr c c2 s o test.toml settings.toml profile privatekey.pem Swupd_Root.pem
     if [ -f /etc/ca-certs/trusted/Swupd_Root.pem ]; then
         sudo clrtrust remove /etc/ca-certs/trusted/Swupd_Root.pem &> /dev/null
     fi
@@ -37,6 +37,10 @@ source /usr/lib/os-release
 sed -e "s|@VERSION@|${VERSION_ID}|" example-config.toml > test.toml
 sed -i "s|@TESTDIR@|file:///${PWD}/s/www/update|" test.toml
 
+# Keep the shell profile snippets for the test content away from the system's
+mkdir -p profile
+printf 'profiledir = "%s"\nfishdir = "%s"\n' "${PWD}/profile" "${PWD}/profile" > settings.toml
+
 # Build content
 sudo "${BASEDIR}/clr-user-bundles.py" s c test.toml
 if [ $? -ne 0 ]; then
@@ -52,7 +56,7 @@ if [ $? -ne 0 ]; then
 fi
 
 # Install content
-sudo "${BASEDIR}/swupd-3rd-party" add "file:///${PWD}/s/www/update" -c "${PWD}/o" -p
+sudo "${BASEDIR}/swupd-3rd-party" add "file:///${PWD}/s/www/update" -c "${PWD}/o" --config "${PWD}/settings.toml" -p -y
 if [ $? -ne 0 ]; then
     echo "Install content failed"
     cleanup 1
@@ -72,13 +76,17 @@ if [ -d "${PWD}/o/bin" ]; then
 fi
 
 # Run post process job manually
-sudo "${BASEDIR}/3rd-party-post" -c "${PWD}/o"
+sudo "${BASEDIR}/3rd-party-post" -c "${PWD}/o" --config "${PWD}/settings.toml"
 if [ $? -ne 0 ]; then
     echo "Post process of update failed"
     cleanup 1
 fi
 
 # Verify post process job worked
+if [ ! -f profile/3rd-party.sh ] || [ ! -f profile/3rd-party.fish ]; then
+    echo "Post process job failed to write shell profiles"
+    cleanup 1
+fi
 PATH="$PWD/o/bin:$PATH" test.sh | grep baz -q
 if [ $? -ne 0 ]; then
     echo "Post process job failed to verify"
@@ -98,7 +106,7 @@ if [ $? -ne 0 ]; then
 fi
 
 # Update to content2
-sudo "${BASEDIR}/swupd-3rd-party" update -c "${PWD}/o"
+sudo "${BASEDIR}/swupd-3rd-party" update -c "${PWD}/o" --config "${PWD}/settings.toml"
 if [ $? -ne 0 ]; then
     echo "Update to content2 failed"
     cleanup 1
@@ -124,14 +132,14 @@ if [ $? -ne 0 ]; then
 fi
 
 # Verify remove works
-sudo "${BASEDIR}/swupd-3rd-party" remove "file:///${PWD}/s/www/update" test -c "${PWD}/o"
+sudo "${BASEDIR}/swupd-3rd-party" remove "file:///${PWD}/s/www/update" test -c "${PWD}/o" --config "${PWD}/settings.toml"
 if [ $? -ne 0 ]; then
     echo "Remove content failed"
     cleanup 1
 fi
 
 # Run post process on removal
-sudo "${BASEDIR}/3rd-party-post" -c "${PWD}/o"
+sudo "${BASEDIR}/3rd-party-post" -c "${PWD}/o" --config "${PWD}/settings.toml"
 if [ $? -ne 0 ]; then
     echo "Post process of removal failed"
     cleanup 1
@@ -142,5 +150,9 @@ if [ -d o/bin ]; then
     echo "Post process of removal failed to verify"
     cleanup 1
 fi
+if [ -f profile/3rd-party.sh ] || [ -f profile/3rd-party.fish ]; then
+    echo "Post process of removal failed to remove shell profiles"
+    cleanup 1
+fi
 
 cleanup 0